@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"strconv"
+
+	ibf "github.com/calebcase/ibf/lib"
+	"github.com/spf13/cobra"
+)
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle PATH SIZE [SIZE...]",
+	Short: "Create a new bundle holding one set of each size. Elements inserted into the bundle are added to every set.",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var path = args[0]
+
+		sizes := make([]uint64, len(args)-1)
+		for j, arg := range args[1:] {
+			sizes[j], err = strconv.ParseUint(arg, 10, 64)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = parseOptions()
+		if err != nil {
+			return err
+		}
+
+		bundle, err := ibf.NewBundle(sizes, cfg.seed, cfg.options)
+		if err != nil {
+			return err
+		}
+
+		return create(path, bundle)
+	},
+}
+
+func init() {
+	addOptionFlags(bundleCmd)
+
+	bundleCmd.Flags().Int64Var(&cfg.seed, "seed", 0, "Seed for the hash parameters.")
+
+	RootCmd.AddCommand(bundleCmd)
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	ibf "github.com/calebcase/ibf/lib"
+	"github.com/zeebo/errs"
+)
+
+// In chunk mode the input is split at content defined boundaries and each
+// chunk is inserted on its own. The IBF only stores the digest of the chunk
+// so that a chunk matches wherever it is in the input, while the index
+// records the offset the chunk was found at followed by the chunk:
+//
+//	offset (8 bytes, big endian) | chunk
+//
+// The average chunk size is kept in the annotations of the IBF since only
+// IBFs chunked the same way can be compared.
+
+// metaChunkSize is the annotation holding the average chunk size.
+const metaChunkSize = "chunk.size"
+
+// chunkRecord returns the index record of the chunk found at offset.
+func chunkRecord(offset uint64, chunk []byte) []byte {
+	record := make([]byte, 8+len(chunk))
+	binary.BigEndian.PutUint64(record, offset)
+	copy(record[8:], chunk)
+
+	return record
+}
+
+// parseChunkRecord returns the offset and the chunk of the index record.
+func parseChunkRecord(record []byte) (offset uint64, chunk []byte, err error) {
+	if len(record) < 8 {
+		return 0, nil, errs.New("truncated chunk record")
+	}
+
+	return binary.BigEndian.Uint64(record), record[8:], nil
+}
+
+// prepareChunks checks that the IBF can hold chunks of the size and records
+// the size in its annotations.
+func prepareChunks(path string, f filter, size int) (*ibf.IBF, error) {
+	set, ok := f.(*ibf.IBF)
+	if !ok || !set.HashKeys {
+		return nil, errs.New("%s: chunking needs an IBF with hashed keys (see create --hash-keys)", path)
+	}
+
+	value := fmt.Sprint(size)
+
+	if previous, ok := set.Meta[metaChunkSize]; ok && previous != value {
+		return nil, errs.New("%s: already holds chunks of size %s", path, previous)
+	}
+
+	if set.Meta == nil {
+		set.Meta = map[string]string{}
+	}
+	set.Meta[metaChunkSize] = value
+
+	return set, nil
+}
+
+// resolveChunks replaces the digests in the difference by the offset and the
+// length of their chunk, written as OFFSET+LENGTH. Digests without a record
+// are replaced by their hex encoding.
+func resolveChunks(diff *ibf.Difference, paths []string) error {
+	digests := append(append([][]byte{}, diff.Left...), diff.Right...)
+
+	records, err := lookup(paths, digests)
+	if err != nil {
+		return err
+	}
+
+	for _, side := range [][][]byte{diff.Left, diff.Right} {
+		for j, digest := range side {
+			record, ok := records[string(digest)]
+			if !ok {
+				side[j] = []byte(hex.EncodeToString(digest))
+
+				continue
+			}
+
+			offset, chunk, err := parseChunkRecord(record)
+			if err != nil {
+				return err
+			}
+
+			side[j] = []byte(fmt.Sprintf("%d+%d", offset, len(chunk)))
+		}
+	}
+
+	return nil
+}
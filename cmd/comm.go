@@ -7,40 +7,103 @@ import (
 
 	ibf "github.com/calebcase/ibf/lib"
 	"github.com/spf13/cobra"
+	"github.com/zeebo/errs"
 )
 
+// indexed splits the element inserted with --block-index into the index of
+// the block and the block.
 func indexed(data []byte) (int64, []byte) {
-	return int64(binary.LittleEndian.Uint64(data[len(data)-8:])), data[:len(data)-9]
+	if len(data) < 8 {
+		return -1, data
+	}
+
+	return int64(binary.BigEndian.Uint64(data[len(data)-8:])), data[:len(data)-8]
 }
 
 var commCmd = &cobra.Command{
 	Use:   "comm IBF1 IBF2",
-	Short: "Compare IBF1 and IBF2.",
+	Short: "Compare IBF1 and IBF2. If both are IBLTs keys with changed values are listed in a third column.",
 	Args:  cobra.ExactArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 		// Load IBF1 and IBF2.
 		paths := args
 		sets := [2]*ibf.IBF{}
 
-		for i, path := range paths {
-			if i > 1 {
-				break
+		v, err := load(paths[0])
+		if err != nil {
+			return err
+		}
+
+		if t, ok := v.(*ibf.IBLT); ok {
+			return commTables(paths, t)
+		}
+
+		var set *ibf.IBF
+		var diff *ibf.Difference
+
+		bundle, ok := v.(*ibf.Bundle)
+		if ok {
+			var other *ibf.Bundle
+
+			other, err = openBundle(paths[1])
+			if err != nil {
+				return err
+			}
+
+			err = bundle.Subtract(other)
+			if err != nil {
+				return err
+			}
+
+			diff, set, err = bundle.Decode()
+
+			fmt.Fprintf(os.Stderr, "Compared using the members of size %d.\n", set.Size)
+		} else {
+			// NOTE: IBF1 was already loaded to find out what it
+			// holds.
+			sets[0], ok = v.(*ibf.IBF)
+			if !ok {
+				return errs.New("%s: not an IBF", paths[0])
 			}
 
-			sets[i], err = open(path)
+			sets[1], err = open(paths[1])
 			if err != nil {
 				return err
 			}
+
+			if sets[0].Meta[metaChunkSize] != sets[1].Meta[metaChunkSize] {
+				return errs.New("%s and %s were not chunked the same way", paths[0], paths[1])
+			}
+
+			// Subtract IBF2 from IBF1. What remains positive is
+			// only in IBF1 and what remains negative is only in
+			// IBF2.
+			set = sets[0].Clone()
+			err = set.Subtract(sets[1])
+			if err != nil {
+				return err
+			}
+
+			diff, err = set.Decode()
 		}
 
-		// Subtract IBF2 from IBF1.
-		set := sets[0].Clone()
-		set.Subtract(sets[1])
+		if sets[0] != nil && sets[0].Meta[metaChunkSize] != "" {
+			// NOTE: Chunks are listed by where they are in the
+			// input rather than by their contents.
+			resolveErr := resolveChunks(diff, append([]string{indexPath(paths[0]), indexPath(paths[1])}, cfg.indexes...))
+			if resolveErr != nil {
+				return resolveErr
+			}
+		} else if set.HashKeys {
+			resolveErr := resolve(diff, append([]string{indexPath(paths[0]), indexPath(paths[1])}, cfg.indexes...))
+			if resolveErr != nil {
+				return resolveErr
+			}
+		}
 
 		// Produce the two-column output.
-		leftEmpty := true
-		for val, err := set.Pop(); err == nil; val, err = set.Pop() {
-			if !cfg.suppressLeft {
+		if !cfg.suppressLeft {
+			for _, val := range diff.Left {
 				if cfg.blockIndex >= 0 {
 					idx, bytes := indexed(val)
 					fmt.Printf("%d:%s\n", idx, string(bytes))
@@ -49,16 +112,9 @@ var commCmd = &cobra.Command{
 				}
 			}
 		}
-		if !cfg.suppressLeft {
-			leftEmpty = set.IsEmpty()
-		}
-
-		set = sets[1].Clone()
-		set.Subtract(sets[0])
 
-		rightEmpty := true
-		for val, err := set.Pop(); err == nil; val, err = set.Pop() {
-			if !cfg.suppressRight {
+		if !cfg.suppressRight {
+			for _, val := range diff.Right {
 				if cfg.blockIndex >= 0 {
 					idx, bytes := indexed(val)
 					fmt.Printf("%s%d:%s\n", cfg.columnDelimiter, idx, string(bytes))
@@ -67,24 +123,10 @@ var commCmd = &cobra.Command{
 				}
 			}
 		}
-		if !cfg.suppressRight {
-			rightEmpty = set.IsEmpty()
-		}
 
 		// Incomplete listing?
-		if !leftEmpty || !rightEmpty {
-			// Which side was empty?
-			side := ""
-			switch {
-			case leftEmpty && rightEmpty:
-				side = "left and right"
-			case leftEmpty && !rightEmpty:
-				side = "left"
-			case !leftEmpty && rightEmpty:
-				side = "right"
-			}
-
-			fmt.Fprintf(os.Stderr, "Unable to list all elements (%s).\n", side)
+		if err != nil {
+			incomplete(diff, err)
 
 			os.Exit(1)
 		}
@@ -93,14 +135,72 @@ var commCmd = &cobra.Command{
 	},
 }
 
+// commTables compares two IBLTs. Entries only in the first are printed as
+// key=value in the first column, entries only in the second in the second
+// column and keys whose value changed in the third column followed by both
+// values. The first IBLT was already loaded from the first path.
+func commTables(paths []string, first *ibf.IBLT) (err error) {
+	tables := [2]*ibf.IBLT{first}
+
+	tables[1], err = openTable(paths[1])
+	if err != nil {
+		return err
+	}
+
+	t := tables[0].Clone()
+	err = t.Subtract(tables[1])
+	if err != nil {
+		return err
+	}
+
+	diff, err := t.Decode()
+
+	left, right, changed := diff.Compare()
+
+	if !cfg.suppressLeft {
+		for _, pair := range left {
+			fmt.Printf("%s%s%s\n", pair.Key, cfg.separator, pair.Value)
+		}
+	}
+
+	if !cfg.suppressRight {
+		for _, pair := range right {
+			fmt.Printf("%s%s%s%s\n", cfg.columnDelimiter, pair.Key, cfg.separator, pair.Value)
+		}
+	}
+
+	if !cfg.suppressChanged {
+		for _, change := range changed {
+			fmt.Printf("%s%s%s%s%s%s%s\n",
+				cfg.columnDelimiter, cfg.columnDelimiter,
+				change.Key, cfg.separator, change.Left,
+				cfg.columnDelimiter, change.Right)
+		}
+	}
+
+	// Incomplete listing?
+	if err != nil {
+		incompleteCells(diff.Remaining, err)
+
+		os.Exit(1)
+	}
+
+	return nil
+}
+
 func init() {
 	commCmd.Flags().StringVarP(&cfg.columnDelimiter, "output-delimiter", "d", "\t", "Separate columns with STR.")
 
 	commCmd.Flags().BoolVarP(&cfg.suppressLeft, "left", "1", false, "Suppress values unique to left-side (IBF1).")
 	commCmd.Flags().BoolVarP(&cfg.suppressRight, "right", "2", false, "Suppress values unique to right-side (IBF2).")
+	commCmd.Flags().BoolVarP(&cfg.suppressChanged, "changed", "3", false, "Suppress keys whose value changed (IBLTs only).")
+
+	commCmd.Flags().StringVarP(&cfg.separator, "separator", "s", "=", "Separate the keys and values of IBLT entries with STR.")
 
 	commCmd.Flags().Int64VarP(&cfg.blockIndex, "block-index", "i", -1, "Values are assumed to be prefixed with an int64 index.")
 	commCmd.Flags().Lookup("block-index").NoOptDefVal = "0"
 
+	commCmd.Flags().StringSliceVar(&cfg.indexes, "index", nil, "Resolve hashed keys using these indexes in addition to IBF1.index and IBF2.index.")
+
 	RootCmd.AddCommand(commCmd)
 }
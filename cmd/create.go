@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"fmt"
 	"strconv"
+	"strings"
 
 	ibf "github.com/calebcase/ibf/lib"
 	"github.com/spf13/cobra"
@@ -27,12 +29,47 @@ var createCmd = &cobra.Command{
 			}
 		}
 
-		set := ibf.NewIBF(size, seed)
+		err = parseOptions()
+		if err != nil {
+			return err
+		}
+
+		set, err := ibf.NewIBFWithOptions(size, seed, cfg.options)
+		if err != nil {
+			return err
+		}
 
 		return create(path, set)
 	},
 }
 
+// parseOptions sets the options that are given by name on the command line.
+func parseOptions() (err error) {
+	cfg.options.Placement, err = ibf.ParsePlacement(cfg.placement)
+	if err != nil {
+		return err
+	}
+
+	cfg.options.Scheme, err = ibf.ParseScheme(cfg.scheme)
+
+	return err
+}
+
+// addOptionFlags adds the flags configuring new IBFs to the command.
+func addOptionFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVarP(&cfg.options.Hashes, "hashes", "k", ibf.DefaultOptions.Hashes, "Place each key in K cells.")
+	cmd.Flags().StringVar(&cfg.placement, "placement", ibf.DefaultOptions.Placement.String(), "Choose cells by probing the whole IBF (probe), from one sub-table per hash (partitioned) or by the hash modulo the size (modulo).")
+
+	cmd.Flags().StringVar(&cfg.scheme, "scheme", ibf.DefaultOptions.Scheme.String(), "Hash each key once per hash function (independent) or derive all positions from one 128-bit hash (double).")
+	cmd.Flags().IntVar(&cfg.options.DigestBits, "digest-bits", ibf.DefaultOptions.DigestBits, "Width of the per cell digest used to detect cells holding a single key (64 or 128).")
+	cmd.Flags().IntVar(&cfg.options.KeyWidth, "key-width", 0, "Require every key to be exactly N bytes and store them without a length (0 allows any length).")
+	cmd.Flags().BoolVar(&cfg.options.HashKeys, "hash-keys", false, "Store the SHA-256 digest of each element instead of the element. Inserted elements are recorded in IBF.index to resolve listings.")
+	cmd.Flags().BoolVar(&cfg.options.Multiset, "multiset", false, "Count repeated elements instead of letting them cancel out.")
+	cmd.Flags().StringVar(&cfg.options.Hash, "hash", ibf.DefaultOptions.Hash, fmt.Sprintf("Hash algorithm to use (%s).", strings.Join(ibf.HasherNames(), ", ")))
+}
+
 func init() {
+	addOptionFlags(createCmd)
+
 	RootCmd.AddCommand(createCmd)
 }
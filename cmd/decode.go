@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	ibf "github.com/calebcase/ibf/lib"
+	"github.com/spf13/cobra"
+	"github.com/zeebo/errs"
+)
+
+var decodeCmd = &cobra.Command{
+	Use:   "decode KEYS [SYMBOLS...]",
+	Short: "Compare the keys in KEYS (one per line) with the sets encoded by the symbol streams in SYMBOLS (or stdin).",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		keys, paths := args[0], args[1:]
+
+		if len(paths) == 0 {
+			if keys == "-" {
+				return errs.New("keys and symbols can't both be read from stdin")
+			}
+
+			paths = []string{"-"}
+		}
+
+		var d *ibf.Decoder
+
+		for _, path := range paths {
+			d, err = decodeStream(d, keys, path)
+			if err != nil {
+				return err
+			}
+
+			if d.Decoded() {
+				break
+			}
+		}
+
+		diff := d.Difference()
+
+		// Produce the two-column output. Keys only in KEYS come first
+		// and keys only in the stream second.
+		if !cfg.suppressLeft {
+			for _, val := range diff.Left {
+				fmt.Printf("%s\n", string(val))
+			}
+		}
+
+		if !cfg.suppressRight {
+			for _, val := range diff.Right {
+				fmt.Printf("%s%s\n", cfg.columnDelimiter, string(val))
+			}
+		}
+
+		if !d.Decoded() {
+			fmt.Fprintf(os.Stderr, "Unable to decode with %d symbols. Continue the stream with --start=%d.\n", d.GetSymbolCount(), d.GetSymbolCount())
+
+			os.Exit(1)
+		}
+
+		return nil
+	},
+}
+
+// decodeStream adds the symbols in the stream at path to the decoder until it
+// has decoded the difference. If the decoder is nil it is created using the
+// hasher of the stream and the keys are added to it first. Otherwise the
+// stream must continue where the last one ended.
+func decodeStream(d *ibf.Decoder, keys, path string) (_ *ibf.Decoder, err error) {
+	in := os.Stdin
+	if path != "-" {
+		in, err = os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			err = errs.Combine(err, in.Close())
+		}()
+	}
+
+	r, err := ibf.NewSymbolReader(in)
+	if err != nil {
+		return nil, errs.New("%s: %v", path, err)
+	}
+
+	if d == nil {
+		d = ibf.NewDecoderWithHash(r.Hasher)
+
+		err = readKeys(keys, d.Add)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.Start != d.GetSymbolCount() {
+		return nil, errs.New("%s: stream starts at symbol %d, expected %d", path, r.Start, d.GetSymbolCount())
+	}
+
+	for !d.Decoded() {
+		symbol, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errs.New("%s: %v", path, err)
+		}
+
+		d.AddSymbol(symbol)
+	}
+
+	return d, nil
+}
+
+func init() {
+	decodeCmd.Flags().StringVarP(&cfg.columnDelimiter, "output-delimiter", "d", "\t", "Separate columns with STR.")
+
+	decodeCmd.Flags().BoolVarP(&cfg.suppressLeft, "left", "1", false, "Suppress values unique to left-side (KEYS).")
+	decodeCmd.Flags().BoolVarP(&cfg.suppressRight, "right", "2", false, "Suppress values unique to right-side (SYMBOLS).")
+
+	RootCmd.AddCommand(decodeCmd)
+}
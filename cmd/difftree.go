@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	ibf "github.com/calebcase/ibf/lib"
+	"github.com/spf13/cobra"
+	"github.com/zeebo/errs"
+)
+
+var diffTreeCmd = &cobra.Command{
+	Use:   "diff-tree A B",
+	Short: "Compare the scans A and B. Paths only in B are listed as added (A), paths only in A as deleted (D) and paths in both whose records differ as modified (M) along with the fields that changed.",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		sets := [2]*ibf.IBF{}
+
+		for i, path := range args {
+			sets[i], err = open(path)
+			if err != nil {
+				return err
+			}
+
+			if _, ok := sets[i].Meta[metaScanFields]; !ok {
+				return errs.New("%s: not a scan", path)
+			}
+		}
+
+		// NOTE: Records only match if they hold the same fields.
+		if sets[0].Meta[metaScanFields] != sets[1].Meta[metaScanFields] {
+			return errs.New("scans record different fields: %s != %s",
+				sets[0].Meta[metaScanFields], sets[1].Meta[metaScanFields])
+		}
+
+		set := sets[0].Clone()
+
+		err = set.Subtract(sets[1])
+		if err != nil {
+			return err
+		}
+
+		diff, err := set.Decode()
+
+		if set.HashKeys {
+			resolveErr := resolve(diff, append([]string{indexPath(args[0]), indexPath(args[1])}, cfg.indexes...))
+			if resolveErr != nil {
+				return resolveErr
+			}
+		}
+
+		for _, change := range compareRecords(diff.Left, diff.Right) {
+			fmt.Println(change)
+		}
+
+		// Incomplete listing?
+		if err != nil {
+			incomplete(diff, err)
+
+			os.Exit(1)
+		}
+
+		return nil
+	},
+}
+
+// compareRecords pairs the records only in A with the records only in B by
+// path and returns a line per path ordered by path.
+func compareRecords(onlyA, onlyB [][]byte) (changes []string) {
+	a := map[string]map[string]string{}
+	b := map[string]map[string]string{}
+
+	for _, record := range onlyA {
+		path, fields := parseRecord(record)
+		a[path] = fields
+	}
+
+	for _, record := range onlyB {
+		path, fields := parseRecord(record)
+		b[path] = fields
+	}
+
+	var paths []string
+	for path := range a {
+		paths = append(paths, path)
+	}
+	for path := range b {
+		if _, ok := a[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		before, inA := a[path]
+		after, inB := b[path]
+
+		switch {
+		case !inA:
+			changes = append(changes, "A\t"+path)
+		case !inB:
+			changes = append(changes, "D\t"+path)
+		default:
+			changes = append(changes, "M\t"+path+"\t"+strings.Join(changedFields(before, after), ","))
+		}
+	}
+
+	return changes
+}
+
+// changedFields returns the sorted names of the fields that differ.
+func changedFields(before, after map[string]string) (names []string) {
+	for name, value := range before {
+		other, ok := after[name]
+		if !ok || other != value {
+			names = append(names, name)
+		}
+	}
+
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func init() {
+	diffTreeCmd.Flags().StringSliceVar(&cfg.indexes, "index", nil, "Resolve hashed keys using these indexes in addition to A.index and B.index.")
+
+	RootCmd.AddCommand(diffTreeCmd)
+}
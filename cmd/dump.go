@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var dumpCmd = &cobra.Command{
+	Use:   "dump PATH",
+	Short: "Print the IBF or strata estimator as JSON.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var path = args[0]
+
+		v, err := load(path)
+		if err != nil {
+			return err
+		}
+
+		return json.NewEncoder(os.Stdout).Encode(v)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(dumpCmd)
+}
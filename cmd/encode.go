@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	ibf "github.com/calebcase/ibf/lib"
+	"github.com/spf13/cobra"
+	"github.com/zeebo/errs"
+)
+
+var encodeCmd = &cobra.Command{
+	Use:   "encode [KEYS]",
+	Short: "Write the rateless coded symbol stream of the keys in KEYS (or stdin, one per line) to stdout.",
+	Args:  cobra.RangeArgs(0, 1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		path := "-"
+		if len(args) > 0 {
+			path = args[0]
+		}
+
+		hasher, err := ibf.NewSeededHasher(cfg.options.Hash, cfg.seed)
+		if err != nil {
+			return err
+		}
+
+		e := ibf.NewEncoderWithHash(hasher)
+
+		err = readKeys(path, e.Add)
+		if err != nil {
+			return err
+		}
+
+		out := bufio.NewWriter(os.Stdout)
+		defer func() {
+			err = errs.Combine(err, out.Flush())
+		}()
+
+		w, err := ibf.NewSymbolWriter(out, hasher, cfg.start)
+		if err != nil {
+			return err
+		}
+
+		for e.GetSymbolCount() < cfg.start {
+			e.Next()
+		}
+
+		// NOTE: Without a symbol count the stream only ends when the
+		// reader goes away.
+		for n := uint64(0); cfg.symbols == 0 || n < cfg.symbols; n++ {
+			err = w.Write(e.Next())
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	encodeCmd.Flags().Uint64VarP(&cfg.symbols, "symbols", "n", 0, "Write N symbols (0 writes symbols until the reader goes away).")
+	encodeCmd.Flags().Uint64Var(&cfg.start, "start", 0, "Skip the first N symbols, e.g. to continue a stream that was not long enough.")
+	encodeCmd.Flags().Int64Var(&cfg.seed, "seed", 0, "Seed for the hash parameters. Both sides must use the same seed.")
+	encodeCmd.Flags().StringVar(&cfg.options.Hash, "hash", ibf.DefaultOptions.Hash, fmt.Sprintf("Hash algorithm to use (%s).", strings.Join(ibf.HasherNames(), ", ")))
+
+	RootCmd.AddCommand(encodeCmd)
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"fmt"
+
+	ibf "github.com/calebcase/ibf/lib"
+	"github.com/spf13/cobra"
+)
+
+var estimateCmd = &cobra.Command{
+	Use:   "estimate STRATA1 STRATA2",
+	Short: "Estimate the size of the difference between STRATA1 and STRATA2.",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		paths := args
+		strata := [2]*ibf.Strata{}
+
+		for i, path := range paths {
+			strata[i], err = openStrata(path)
+			if err != nil {
+				return err
+			}
+		}
+
+		estimate, err := strata[0].Estimate(strata[1])
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%d\n", estimate)
+
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(estimateCmd)
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/calebcase/ibf/reconcile"
+	"github.com/spf13/cobra"
+	"github.com/zeebo/errs"
+)
+
+var fetchCmd = &cobra.Command{
+	Use:   "fetch ADDR OUT",
+	Short: "Fetch a set from the server listening on ADDR and write it to OUT. Without --size the largest size is fetched and the available sizes are listed.",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		client, err := reconcile.Dial(args[0], cfg.name)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			err = errs.Combine(err, client.Close())
+		}()
+
+		sizes := client.GetSizes()
+
+		size := cfg.size
+		if size == 0 {
+			for _, size := range sizes {
+				fingerprint, _ := client.GetFingerprint(size)
+				fmt.Printf("%d\t%016x\n", size, fingerprint)
+			}
+
+			size = sizes[len(sizes)-1]
+		}
+
+		set, err := client.Fetch(size)
+		if err != nil {
+			return err
+		}
+
+		if cfg.strata != "" {
+			strata, err := client.FetchStrata()
+			if err != nil {
+				return err
+			}
+
+			err = create(cfg.strata, strata)
+			if err != nil {
+				return err
+			}
+		}
+
+		return create(args[1], set)
+	},
+}
+
+func init() {
+	fetchCmd.Flags().StringVar(&cfg.name, "name", "", "Fetch the set NAME of the server. Required if it serves more than one.")
+	fetchCmd.Flags().Uint64Var(&cfg.size, "size", 0, "Fetch the set at SIZE.")
+	fetchCmd.Flags().StringVar(&cfg.strata, "strata", "", "Also fetch the strata estimator and write it to FILE.")
+
+	RootCmd.AddCommand(fetchCmd)
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/zeebo/errs"
+)
+
+var foldCmd = &cobra.Command{
+	Use:   "fold IN OUT SIZE",
+	Short: "Fold the IBF IN down to SIZE and write it to OUT. IN must use the modulo or partitioned placement and SIZE must divide its size.",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var in, out = args[0], args[1]
+
+		size, err := strconv.ParseUint(args[2], 10, 64)
+		if err != nil {
+			return err
+		}
+
+		set, err := open(in)
+		if err != nil {
+			return err
+		}
+
+		if size == 0 || set.Size%size != 0 {
+			return errs.New("size %d does not divide %d", size, set.Size)
+		}
+
+		folded, err := set.Fold(set.Size / size)
+		if err != nil {
+			return err
+		}
+
+		return create(out, folded)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(foldCmd)
+}
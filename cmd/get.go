@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var getCmd = &cobra.Command{
+	Use:   "get IBLT KEY",
+	Short: "Print the value of the key. The lookup fails if the key is not found or if the table is too full to tell.",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var path = args[0]
+
+		t, err := openTable(path)
+		if err != nil {
+			return err
+		}
+
+		value, err := t.Get([]byte(args[1]))
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s\n", string(value))
+
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(getCmd)
+}
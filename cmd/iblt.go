@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	ibf "github.com/calebcase/ibf/lib"
+	"github.com/spf13/cobra"
+)
+
+var ibltCmd = &cobra.Command{
+	Use:   "iblt PATH SIZE [SEED]",
+	Short: "Create a new key/value table (IBLT). Optionally specify a seed for the hash parameters.",
+	Args:  cobra.RangeArgs(2, 3),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var path = args[0]
+		var seed int64 = 0
+
+		size, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return err
+		}
+
+		if len(args) > 2 {
+			seed, err = strconv.ParseInt(args[2], 10, 64)
+			if err != nil {
+				return err
+			}
+		}
+
+		t, err := ibf.NewIBLTWithOptions(size, seed, cfg.options)
+		if err != nil {
+			return err
+		}
+
+		return create(path, t)
+	},
+}
+
+func init() {
+	ibltCmd.Flags().IntVarP(&cfg.options.Hashes, "hashes", "k", ibf.DefaultOptions.Hashes, "Place each key in K cells.")
+
+	ibltCmd.Flags().IntVar(&cfg.options.DigestBits, "digest-bits", ibf.DefaultOptions.DigestBits, "Width of the per cell digest used to detect cells holding a single entry (64 or 128).")
+	ibltCmd.Flags().IntVar(&cfg.options.KeyWidth, "key-width", 0, "Require every key to be exactly N bytes and store them without a length (0 allows any length).")
+	ibltCmd.Flags().StringVar(&cfg.options.Hash, "hash", ibf.DefaultOptions.Hash, fmt.Sprintf("Hash algorithm to use (%s).", strings.Join(ibf.HasherNames(), ", ")))
+
+	RootCmd.AddCommand(ibltCmd)
+}
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+
+	ibf "github.com/calebcase/ibf/lib"
+	"github.com/zeebo/errs"
+)
+
+// An index maps the digests stored by IBFs that hash their keys back to the
+// original elements. It is a sequence of records each made of the digest, the
+// uvarint length of the element and the element. Records are only ever
+// appended, so elements that were later removed from the IBF stay in the
+// index.
+
+// indexPath returns the default path of the index for the IBF at path.
+func indexPath(path string) string {
+	return path + ".index"
+}
+
+// indexWriter appends records to an index.
+type indexWriter struct {
+	file    *os.File
+	w       *bufio.Writer
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func openIndex(path string) (*indexWriter, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &indexWriter{
+		file: file,
+		w:    bufio.NewWriter(file),
+	}, nil
+}
+
+// Add appends the record for the element.
+func (iw *indexWriter) Add(element []byte) (err error) {
+	return iw.AddRecord(ibf.KeyDigest(element), element)
+}
+
+// AddRecord appends a record resolving the digest to the element. The element
+// need not be the one the digest was computed from, which lets the index
+// carry more than the IBF (see chunk records).
+func (iw *indexWriter) AddRecord(digest, element []byte) (err error) {
+	_, err = iw.w.Write(digest)
+	if err != nil {
+		return err
+	}
+
+	n := binary.PutUvarint(iw.scratch[:], uint64(len(element)))
+
+	_, err = iw.w.Write(iw.scratch[:n])
+	if err != nil {
+		return err
+	}
+
+	_, err = iw.w.Write(element)
+
+	return err
+}
+
+// Close flushes the pending records and closes the index.
+func (iw *indexWriter) Close() error {
+	return errs.Combine(iw.w.Flush(), iw.file.Close())
+}
+
+// lookup scans the indexes for the elements of the digests. Indexes that do
+// not exist are skipped. Only the elements that are wanted are read into
+// memory.
+func lookup(paths []string, digests [][]byte) (elements map[string][]byte, err error) {
+	elements = map[string][]byte{}
+
+	wanted := map[string]bool{}
+	for _, digest := range digests {
+		wanted[string(digest)] = true
+	}
+
+	for _, path := range paths {
+		if len(elements) == len(wanted) {
+			break
+		}
+
+		err = scanIndex(path, func(digest string, size uint64, r *bufio.Reader) error {
+			if !wanted[digest] || elements[digest] != nil {
+				_, err := r.Discard(int(size))
+
+				return err
+			}
+
+			element := make([]byte, size)
+
+			_, err := io.ReadFull(r, element)
+			if err != nil {
+				return err
+			}
+
+			elements[digest] = element
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return elements, nil
+}
+
+// scanIndex calls fn for each record in the index with the digest and the size
+// of the element. fn must consume exactly size bytes from the reader.
+func scanIndex(path string, fn func(digest string, size uint64, r *bufio.Reader) error) (err error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errs.Combine(err, file.Close())
+	}()
+
+	r := bufio.NewReader(file)
+	digest := make([]byte, ibf.KeyDigestSize)
+
+	for {
+		_, err = io.ReadFull(r, digest)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errs.New("%s: truncated index: %v", path, err)
+		}
+
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return errs.New("%s: truncated index: %v", path, err)
+		}
+
+		err = fn(string(digest), size, r)
+		if err != nil {
+			return errs.New("%s: truncated index: %v", path, err)
+		}
+	}
+}
+
+// resolve replaces the digests in the difference by the elements found in the
+// indexes. Digests without an element are replaced by their hex encoding.
+func resolve(diff *ibf.Difference, paths []string) error {
+	digests := append(append([][]byte{}, diff.Left...), diff.Right...)
+
+	elements, err := lookup(paths, digests)
+	if err != nil {
+		return err
+	}
+
+	for _, side := range [][][]byte{diff.Left, diff.Right} {
+		for j, digest := range side {
+			element, ok := elements[string(digest)]
+			if !ok {
+				element = []byte(hex.EncodeToString(digest))
+			}
+
+			side[j] = element
+		}
+	}
+
+	return nil
+}
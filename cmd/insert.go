@@ -7,7 +7,9 @@ import (
 	"os"
 	"strings"
 
+	ibf "github.com/calebcase/ibf/lib"
 	"github.com/spf13/cobra"
+	"github.com/zeebo/errs"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
@@ -30,15 +32,70 @@ var insertCmd = &cobra.Command{
 			}
 		}
 
-		set, err := open(path)
+		set, err := openFilter(path)
 		if err != nil {
 			return err
 		}
 
+		var chunker *ibf.Chunker
+
+		if cfg.chunkSize > 0 {
+			if cfg.blockSize >= 0 || len(args) == 2 {
+				return errs.New("--chunk-size only splits stdin and can't be combined with --block-size")
+			}
+
+			chunker, err = ibf.NewChunker(cfg.chunkSize)
+			if err != nil {
+				return err
+			}
+
+			set, err = prepareChunks(path, set, cfg.chunkSize)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Sets that hash their keys only store digests. Record the
+		// elements in the index so that they can be resolved later.
+		var index *indexWriter
+
+		if hashesKeys(set) {
+			if cfg.index == "" {
+				cfg.index = indexPath(path)
+			}
+
+			index, err = openIndex(cfg.index)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				err = errs.Combine(err, index.Close())
+			}()
+		}
+
+		// NOTE: The element is only recorded in the index once the
+		// set accepted it.
+		insert := func(element []byte) error {
+			err := set.Insert(element)
+			if err != nil {
+				return err
+			}
+
+			if index != nil {
+				return index.Add(element)
+			}
+
+			return nil
+		}
+
 		if len(args) == 2 {
-			set.Insert([]byte(args[1]))
+			err = insert([]byte(args[1]))
+			if err != nil {
+				return err
+			}
 		} else {
 			scanner := bufio.NewScanner(os.Stdin)
+			scanner.Buffer(nil, maxLineSize)
 
 			if cfg.blockSize >= 0 {
 				scanBlock := func(data []byte, atEOF bool) (advance int, token []byte, err error) {
@@ -66,20 +123,73 @@ var insertCmd = &cobra.Command{
 				scanner.Split(scanBlock)
 			}
 
+			// Indexed blocks of a plain IBF are annotated with
+			// what patch make needs to rebuild the file.
+			var file *blockFile
+
+			if set, ok := set.(*ibf.IBF); ok && cfg.blockSize > 0 && cfg.blockIndex >= 0 {
+				file = newBlockFile(set)
+			}
+
+			if chunker != nil {
+				scanner.Buffer(make([]byte, chunker.Max), chunker.Max)
+				scanner.Split(chunker.Split)
+			}
+
 			count := -1
+			offset := uint64(0)
+
+			// NOTE: A chunk repeated in the input is only inserted
+			// once. Inserting it again would cancel it out of the
+			// IBF.
+			seen := map[string]bool{}
 
 			for scanner.Scan() {
 				count++
 
 				bytes := scanner.Bytes()
 
+				if chunker != nil {
+					digest := ibf.KeyDigest(bytes)
+					start := offset
+					offset += uint64(len(bytes))
+
+					if seen[string(digest)] {
+						continue
+					}
+					seen[string(digest)] = true
+
+					err = set.Insert(bytes)
+					if err != nil {
+						return err
+					}
+
+					err = index.AddRecord(digest, chunkRecord(start, bytes))
+					if err != nil {
+						return err
+					}
+
+					if echoed {
+						fmt.Printf("%d+%d\n", start, len(bytes))
+					}
+
+					continue
+				}
+
 				if cfg.blockSize >= 0 && cfg.blockIndex >= 0 {
+					if file != nil {
+						file.add(bytes)
+					}
+
 					idx := make([]byte, 8)
-					binary.BigEndian.PutUint64(idx, uint64(count))
+					binary.BigEndian.PutUint64(idx, uint64(cfg.blockIndex+int64(count)))
 					bytes = append(bytes, idx...)
 				}
 
-				set.Insert(bytes)
+				err = insert(bytes)
+				if err != nil {
+					return err
+				}
 
 				if echoed {
 					fmt.Printf("%s\n", string(bytes))
@@ -89,6 +199,10 @@ var insertCmd = &cobra.Command{
 			if err != nil {
 				return err
 			}
+
+			if file != nil {
+				file.record(set.(*ibf.IBF))
+			}
 		}
 
 		return create(path, set)
@@ -104,5 +218,12 @@ func init() {
 	insertCmd.Flags().Int64VarP(&cfg.blockIndex, "block-index", "i", -1, "Suffix each block with an int64 index (starting at the provided value).")
 	insertCmd.Flags().Lookup("block-index").NoOptDefVal = "0"
 
+	insertCmd.Flags().IntVarP(&cfg.chunkSize, "chunk-size", "c", 0, "Split the input at content defined boundaries into chunks of SIZE bytes on average (a power of two). Needs an IBF with hashed keys.")
+	insertCmd.Flags().Lookup("chunk-size").NoOptDefVal = "8192"
+
+	insertCmd.Flags().StringVarP(&cfg.separator, "separator", "s", "=", "Split the elements inserted into an IBLT into key and value at the first STR.")
+
+	insertCmd.Flags().StringVar(&cfg.index, "index", "", "Record the elements of an IBF with hashed keys in this index (default is IBF.index).")
+
 	RootCmd.AddCommand(insertCmd)
 }
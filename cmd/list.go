@@ -6,6 +6,7 @@ import (
 
 	ibf "github.com/calebcase/ibf/lib"
 	"github.com/spf13/cobra"
+	"github.com/zeebo/errs"
 )
 
 var listCmd = &cobra.Command{
@@ -15,57 +16,110 @@ var listCmd = &cobra.Command{
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 		var path = args[0]
 
-		set, err := open(path)
+		v, err := load(path)
 		if err != nil {
 			return err
 		}
 
-		leftEmpty := true
-		for val, err := set.Pop(); err == nil; val, err = set.Pop() {
-			if !cfg.suppressLeft {
-				fmt.Printf("%s\n", string(val))
+		if t, ok := v.(*ibf.IBLT); ok {
+			return listTable(t)
+		}
+
+		var set *ibf.IBF
+		var diff *ibf.Difference
+		var decodeErr error
+
+		bundle, ok := v.(*ibf.Bundle)
+		if ok {
+			diff, set, decodeErr = bundle.Decode()
+
+			fmt.Fprintf(os.Stderr, "Listed using the member of size %d.\n", set.Size)
+		} else {
+			// NOTE: The IBF was already loaded to find out what
+			// the file holds.
+			set, ok = v.(*ibf.IBF)
+			if !ok {
+				return errs.New("%s: not an IBF", path)
 			}
+
+			diff, decodeErr = set.Decode()
 		}
-		if !cfg.suppressLeft {
-			leftEmpty = set.IsEmpty()
+
+		if set.HashKeys {
+			err = resolve(diff, append([]string{indexPath(path)}, cfg.indexes...))
+			if err != nil {
+				return err
+			}
 		}
 
-		rightEmpty := true
-		set.Invert()
-		for val, err := set.Pop(); err == nil; val, err = set.Pop() {
-			if !cfg.suppressRight {
-				fmt.Printf("%s\n", string(val))
+		if !cfg.suppressLeft {
+			for j, val := range diff.Left {
+				if cfg.showCounts {
+					fmt.Printf("%s\t%d\n", string(val), diff.LeftCounts[j])
+				} else {
+					fmt.Printf("%s\n", string(val))
+				}
 			}
 		}
+
 		if !cfg.suppressRight {
-			rightEmpty = set.IsEmpty()
+			for j, val := range diff.Right {
+				if cfg.showCounts {
+					fmt.Printf("%s\t%d\n", string(val), diff.RightCounts[j])
+				} else {
+					fmt.Printf("%s\n", string(val))
+				}
+			}
 		}
 
 		// Incomplete listing?
-		if !leftEmpty || !rightEmpty {
-			// Which side was empty?
-			side := ""
-			switch {
-			case leftEmpty && rightEmpty:
-				side = "left and right"
-			case leftEmpty && !rightEmpty:
-				side = "left"
-			case !leftEmpty && rightEmpty:
-				side = "right"
-			}
+		if decodeErr != nil {
+			incomplete(diff, decodeErr)
 
-			fmt.Fprintf(os.Stderr, "Unable to list all elements (%s).\n", side)
-
-			return ibf.ErrNoPureCell
+			return decodeErr
 		}
 
 		return nil
 	},
 }
 
+// listTable prints the entries of the IBLT as key=value.
+func listTable(t *ibf.IBLT) error {
+	diff, err := t.Decode()
+
+	sides := [][]ibf.Pair{}
+	if !cfg.suppressLeft {
+		sides = append(sides, diff.Left)
+	}
+	if !cfg.suppressRight {
+		sides = append(sides, diff.Right)
+	}
+
+	for _, side := range sides {
+		for _, pair := range side {
+			fmt.Printf("%s%s%s\n", pair.Key, cfg.separator, pair.Value)
+		}
+	}
+
+	// Incomplete listing?
+	if err != nil {
+		incompleteCells(diff.Remaining, err)
+
+		return err
+	}
+
+	return nil
+}
+
 func init() {
 	listCmd.Flags().BoolVarP(&cfg.suppressLeft, "left", "1", false, "Suppress values unique to left-side (positive count).")
 	listCmd.Flags().BoolVarP(&cfg.suppressRight, "right", "2", false, "Suppress values unique to right-side (negative count).")
 
+	listCmd.Flags().BoolVarP(&cfg.showCounts, "count", "c", false, "Print the signed multiplicity after each value separated by a tab.")
+
+	listCmd.Flags().StringVarP(&cfg.separator, "separator", "s", "=", "Separate the keys and values of IBLT entries with STR.")
+
+	listCmd.Flags().StringSliceVar(&cfg.indexes, "index", nil, "Resolve hashed keys using these indexes in addition to IBF.index.")
+
 	RootCmd.AddCommand(listCmd)
 }
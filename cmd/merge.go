@@ -28,16 +28,25 @@ var mergeCmd = &cobra.Command{
 		}
 
 		// Attempt to remove the elements in first from second and then
-		// list the remainder. For each of the remainder, insert into
-		// first.
-		sets[1].Subtract(sets[0])
-		for val, err := sets[1].Pop(); err == nil; val, err = sets[1].Pop() {
-			sets[0].Insert(val)
+		// list the remainder. For each of the remainder only in the
+		// second, insert into first.
+		err = sets[1].Subtract(sets[0])
+		if err != nil {
+			return err
 		}
-		if !sets[1].IsEmpty() {
+
+		diff, err := sets[1].Decode()
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "More elements in the set, but unable to retrieve.\n")
 
-			return ibf.ErrNoPureCell
+			return err
+		}
+
+		for _, val := range diff.Left {
+			err = sets[0].InsertKey(val)
+			if err != nil {
+				return err
+			}
 		}
 
 		var output string
@@ -0,0 +1,294 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	ibf "github.com/calebcase/ibf/lib"
+	"github.com/spf13/cobra"
+	"github.com/zeebo/errs"
+)
+
+// Inserting a file with --block-size and --block-index annotates the IBF with
+// the block size, the index of the first block, the length of the file and
+// its SHA-256 digest. Together with the blocks decoded from the difference
+// this is all patch make needs to rebuild the file from an older version.
+const (
+	metaBlockSize   = "block.size"
+	metaBlockStart  = "block.start"
+	metaBlockLength = "block.length"
+	metaBlockDigest = "block.sha256"
+)
+
+// blockFile tracks the file inserted in blocks.
+type blockFile struct {
+	length uint64
+	h      hash.Hash
+
+	// NOTE: An IBF holding the blocks of more than one file can't be used
+	// to rebuild either of them.
+	multiple bool
+}
+
+func newBlockFile(set *ibf.IBF) *blockFile {
+	_, multiple := set.Meta[metaBlockDigest]
+
+	return &blockFile{
+		h:        sha256.New(),
+		multiple: multiple,
+	}
+}
+
+// add hashes the next block of the file.
+func (f *blockFile) add(block []byte) {
+	f.length += uint64(len(block))
+	_, _ = f.h.Write(block)
+}
+
+// record annotates the IBF with the file.
+func (f *blockFile) record(set *ibf.IBF) {
+	if f.multiple {
+		for _, key := range []string{metaBlockSize, metaBlockStart, metaBlockLength, metaBlockDigest} {
+			delete(set.Meta, key)
+		}
+
+		return
+	}
+
+	if set.Meta == nil {
+		set.Meta = map[string]string{}
+	}
+
+	set.Meta[metaBlockSize] = strconv.Itoa(cfg.blockSize)
+	set.Meta[metaBlockStart] = strconv.FormatInt(cfg.blockIndex, 10)
+	set.Meta[metaBlockLength] = strconv.FormatUint(f.length, 10)
+	set.Meta[metaBlockDigest] = hex.EncodeToString(f.h.Sum(nil))
+}
+
+// blockPatch returns an empty patch for the file annotated in the IBF along
+// with the index of its first block.
+func blockPatch(path string, set *ibf.IBF) (p *ibf.Patch, start int64, err error) {
+	for _, key := range []string{metaBlockSize, metaBlockStart, metaBlockLength, metaBlockDigest} {
+		if _, ok := set.Meta[key]; !ok {
+			return nil, 0, errs.New("%s: does not hold the blocks of a single file (see insert --block-size --block-index)", path)
+		}
+	}
+
+	p = &ibf.Patch{
+		Blocks: map[uint64][]byte{},
+	}
+
+	p.BlockSize, err = strconv.ParseUint(set.Meta[metaBlockSize], 10, 64)
+	if err == nil {
+		start, err = strconv.ParseInt(set.Meta[metaBlockStart], 10, 64)
+	}
+	if err == nil {
+		p.Length, err = strconv.ParseUint(set.Meta[metaBlockLength], 10, 64)
+	}
+	if err == nil {
+		p.Digest, err = hex.DecodeString(set.Meta[metaBlockDigest])
+	}
+	if err != nil {
+		return nil, 0, errs.New("%s: invalid block annotations: %v", path, err)
+	}
+
+	if p.BlockSize == 0 || p.BlockSize > ibf.MaxPatchBlockSize {
+		return nil, 0, errs.New("%s: invalid block size %d", path, p.BlockSize)
+	}
+
+	return p, start, nil
+}
+
+var patchCmd = &cobra.Command{
+	Use:   "patch",
+	Short: "Transfer files by the blocks that differ.",
+}
+
+var patchMakeCmd = &cobra.Command{
+	Use:   "make OLD NEW",
+	Short: "Make a patch turning the file OLD into the file whose blocks were inserted into the IBF NEW with --block-size and --block-index. The patch is written to stdout unless --output is given.",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		oldPath, newPath := args[0], args[1]
+
+		set, err := open(newPath)
+		if err != nil {
+			return err
+		}
+
+		p, start, err := blockPatch(newPath, set)
+		if err != nil {
+			return err
+		}
+
+		// Removing the blocks of OLD leaves the blocks only NEW has
+		// on the left side of the difference.
+		set = set.Clone()
+
+		old, err := os.Open(oldPath)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			err = errs.Combine(err, old.Close())
+		}()
+
+		buf := make([]byte, p.BlockSize+8)
+
+		for j := int64(0); ; j++ {
+			n, err := io.ReadFull(old, buf[:p.BlockSize])
+			if err == io.EOF {
+				break
+			}
+			if err != nil && err != io.ErrUnexpectedEOF {
+				return err
+			}
+
+			block := append(buf[:n], make([]byte, 8)...)
+			binary.BigEndian.PutUint64(block[n:], uint64(start+j))
+
+			err = set.Remove(block)
+			if err != nil {
+				return err
+			}
+
+			if n < int(p.BlockSize) {
+				break
+			}
+		}
+
+		diff, err := set.Decode()
+		if err != nil {
+			return errs.New("%s and %s differ in too many blocks to decode: %v", oldPath, newPath, err)
+		}
+
+		if set.HashKeys {
+			err = resolveBlocks(diff, append([]string{indexPath(newPath)}, cfg.indexes...))
+			if err != nil {
+				return err
+			}
+		}
+
+		count := p.GetBlockCount()
+
+		for _, element := range diff.Left {
+			idx, block := indexed(element)
+			if idx < start || uint64(idx-start) >= count {
+				return errs.New("%s: block %d is outside of the file", newPath, idx)
+			}
+
+			p.Blocks[uint64(idx-start)] = block
+		}
+
+		fmt.Fprintf(os.Stderr, "Patch replaces %d of %d blocks.\n", len(p.Blocks), count)
+
+		data, err := p.MarshalBinary()
+		if err != nil {
+			return err
+		}
+
+		if cfg.output != "" {
+			return ioutil.WriteFile(cfg.output, data, 0644)
+		}
+
+		_, err = os.Stdout.Write(data)
+
+		return err
+	},
+}
+
+// resolveBlocks replaces the digests of the blocks only in NEW by the blocks
+// found in the indexes. Unlike resolve it fails if a block is missing since
+// the patch can't be made without it.
+func resolveBlocks(diff *ibf.Difference, paths []string) error {
+	elements, err := lookup(paths, diff.Left)
+	if err != nil {
+		return err
+	}
+
+	for j, digest := range diff.Left {
+		element, ok := elements[string(digest)]
+		if !ok {
+			return errs.New("block %x is missing from the indexes", digest)
+		}
+
+		diff.Left[j] = element
+	}
+
+	return nil
+}
+
+var patchApplyCmd = &cobra.Command{
+	Use:   "apply OLD PATCH OUT",
+	Short: "Apply PATCH to the file OLD writing the result to OUT. OUT is only replaced if the result matches the digest recorded in the patch.",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		oldPath, patchPath, outPath := args[0], args[1], args[2]
+
+		data, err := ioutil.ReadFile(patchPath)
+		if err != nil {
+			return err
+		}
+
+		p := &ibf.Patch{}
+
+		err = p.UnmarshalBinary(data)
+		if err != nil {
+			return errs.New("%s: %v", patchPath, err)
+		}
+
+		old, err := os.Open(oldPath)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			err = errs.Combine(err, old.Close())
+		}()
+
+		info, err := old.Stat()
+		if err != nil {
+			return err
+		}
+
+		// NOTE: The result is written next to OUT and only renamed
+		// once verified, so OUT may also be OLD.
+		tmp, err := ioutil.TempFile(filepath.Dir(outPath), "."+filepath.Base(outPath)+".")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				_ = os.Remove(tmp.Name())
+			}
+		}()
+
+		err = tmp.Chmod(info.Mode().Perm())
+		if err == nil {
+			err = p.Apply(old, tmp)
+		}
+		err = errs.Combine(err, tmp.Close())
+		if err != nil {
+			return err
+		}
+
+		return os.Rename(tmp.Name(), outPath)
+	},
+}
+
+func init() {
+	patchMakeCmd.Flags().StringVarP(&cfg.output, "output", "o", "", "Write the patch to FILE.")
+	patchMakeCmd.Flags().StringSliceVar(&cfg.indexes, "index", nil, "Resolve hashed blocks using these indexes in addition to NEW.index.")
+
+	patchCmd.AddCommand(patchMakeCmd)
+	patchCmd.AddCommand(patchApplyCmd)
+
+	RootCmd.AddCommand(patchCmd)
+}
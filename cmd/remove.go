@@ -29,19 +29,26 @@ var removeCmd = &cobra.Command{
 			}
 		}
 
-		set, err := open(path)
+		set, err := openFilter(path)
 		if err != nil {
 			return err
 		}
 
 		if len(args) == 2 {
-			set.Remove([]byte(args[1]))
+			err = set.Remove([]byte(args[1]))
+			if err != nil {
+				return err
+			}
 		} else {
 			scanner := bufio.NewScanner(os.Stdin)
+			scanner.Buffer(nil, maxLineSize)
 			for scanner.Scan() {
 				bytes := scanner.Bytes()
 
-				set.Remove(bytes)
+				err = set.Remove(bytes)
+				if err != nil {
+					return err
+				}
 
 				if echoed {
 					fmt.Printf("%s\n", string(bytes))
@@ -60,5 +67,7 @@ var removeCmd = &cobra.Command{
 func init() {
 	removeCmd.Flags().StringVarP(&cfg.echo, "echo", "e", "auto", "Echo the values from stdin on stdout.")
 
+	removeCmd.Flags().StringVarP(&cfg.separator, "separator", "s", "=", "Split the elements removed from an IBLT into key and value at the first STR.")
+
 	RootCmd.AddCommand(removeCmd)
 }
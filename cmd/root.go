@@ -3,7 +3,9 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
 
+	ibf "github.com/calebcase/ibf/lib"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -13,9 +15,34 @@ var cfg struct {
 	echo            string
 	suppressLeft    bool
 	suppressRight   bool
+	suppressChanged bool
+	showCounts      bool
 	columnDelimiter string
 	blockSize       int
 	blockIndex      int64
+	chunkSize       int
+	options         ibf.Options
+	placement       string
+	scheme          string
+	index           string
+	indexes         []string
+	separator       string
+	seed            int64
+	symbols         uint64
+	start           uint64
+	stdio           bool
+	connect         string
+	listen          string
+	idleTimeout     time.Duration
+	dial            string
+	name            string
+	size            uint64
+	serve           bool
+	scanSize        uint64
+	scanMtime       bool
+	scanContent     bool
+	strata          string
+	output          string
 }
 
 var RootCmd = &cobra.Command{
@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	ibf "github.com/calebcase/ibf/lib"
+	"github.com/spf13/cobra"
+	"github.com/zeebo/errs"
+)
+
+// A scan inserts one record per entry of a directory tree. A record is the
+// path relative to the scanned directory followed by its fields, each written
+// as name=value and separated by NUL bytes, which can't appear in paths:
+//
+//	path NUL size=N NUL mode=M [NUL mtime=T] [NUL sha256=H] [NUL link=L]
+//
+// The fields recorded are kept in the annotations of the IBF so that only
+// scans made with the same fields are compared.
+
+// Annotations recorded by scan.
+const (
+	metaScanFields = "scan.fields"
+	metaScanRoot   = "scan.root"
+)
+
+var scanCmd = &cobra.Command{
+	Use:   "scan DIR OUT",
+	Short: "Walk the tree at DIR and insert a record of the path, size and mode of every entry into a new IBF at OUT. Optionally record modification times and content hashes.",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		root, path := args[0], args[1]
+
+		err = parseOptions()
+		if err != nil {
+			return err
+		}
+
+		set, err := ibf.NewIBFWithOptions(cfg.scanSize, cfg.seed, cfg.options)
+		if err != nil {
+			return err
+		}
+
+		fields := []string{"size", "mode"}
+		if cfg.scanMtime {
+			fields = append(fields, "mtime")
+		}
+		if cfg.scanContent {
+			fields = append(fields, "sha256")
+		}
+
+		set.Meta = map[string]string{
+			metaScanFields: strings.Join(fields, ","),
+			metaScanRoot:   root,
+		}
+
+		var index *indexWriter
+
+		if set.HashKeys {
+			index, err = openIndex(indexPath(path))
+			if err != nil {
+				return err
+			}
+			defer func() {
+				err = errs.Combine(err, index.Close())
+			}()
+		}
+
+		err = filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(root, name)
+			if err != nil {
+				return err
+			}
+
+			if rel == "." {
+				return nil
+			}
+
+			record, err := scanRecord(name, filepath.ToSlash(rel), info)
+			if err != nil {
+				return err
+			}
+
+			err = set.Insert(record)
+			if err != nil {
+				return err
+			}
+
+			if index != nil {
+				return index.Add(record)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return create(path, set)
+	},
+}
+
+// scanRecord returns the record of the entry at name.
+func scanRecord(name, rel string, info os.FileInfo) (record []byte, err error) {
+	var buf bytes.Buffer
+
+	size := int64(0)
+	if info.Mode().IsRegular() {
+		size = info.Size()
+	}
+
+	buf.WriteString(rel)
+	field(&buf, "size", strconv.FormatInt(size, 10))
+	field(&buf, "mode", info.Mode().String())
+
+	if cfg.scanMtime {
+		field(&buf, "mtime", strconv.FormatInt(info.ModTime().UnixNano(), 10))
+	}
+
+	switch {
+	case cfg.scanContent && info.Mode().IsRegular():
+		sum, err := hashFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		field(&buf, "sha256", sum)
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(name)
+		if err != nil {
+			return nil, err
+		}
+
+		field(&buf, "link", target)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// field appends the field to the record.
+func field(buf *bytes.Buffer, name, value string) {
+	buf.WriteByte(0)
+	buf.WriteString(name)
+	buf.WriteByte('=')
+	buf.WriteString(value)
+}
+
+// parseRecord splits the record into its path and fields.
+func parseRecord(record []byte) (path string, fields map[string]string) {
+	parts := strings.Split(string(record), "\x00")
+
+	fields = map[string]string{}
+	for _, part := range parts[1:] {
+		j := strings.Index(part, "=")
+		if j < 0 {
+			fields[part] = ""
+
+			continue
+		}
+
+		fields[part[:j]] = part[j+1:]
+	}
+
+	return parts[0], fields
+}
+
+// hashFile returns the hex encoded SHA-256 digest of the contents of the file.
+func hashFile(name string) (sum string, err error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		err = errs.Combine(err, file.Close())
+	}()
+
+	h := sha256.New()
+
+	_, err = io.Copy(h, file)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func init() {
+	addOptionFlags(scanCmd)
+
+	scanCmd.Flags().Uint64Var(&cfg.scanSize, "size", 1024, "Create the IBF with SIZE cells.")
+	scanCmd.Flags().Int64Var(&cfg.seed, "seed", 0, "Seed for the hash parameters.")
+	scanCmd.Flags().BoolVar(&cfg.scanMtime, "mtime", false, "Record the modification time of every entry.")
+	scanCmd.Flags().BoolVar(&cfg.scanContent, "content", false, "Record the SHA-256 digest of the contents of every regular file.")
+
+	RootCmd.AddCommand(scanCmd)
+}
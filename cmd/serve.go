@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/calebcase/ibf/reconcile"
+	"github.com/spf13/cobra"
+	"github.com/zeebo/errs"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [NAME=]SET...",
+	Short: "Answer peers running sync. With --stdio and --output the difference is written to FILE with elements only in SET in the first column and elements only the peer has in the second.",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		err = checkModes(map[string]bool{
+			"--stdio":  cfg.stdio,
+			"--listen": cfg.listen != "",
+		})
+		if err != nil {
+			return err
+		}
+
+		if cfg.strata != "" && len(args) > 1 {
+			return errs.New("--strata requires a single SET")
+		}
+
+		if cfg.stdio {
+			if len(args) > 1 {
+				return errs.New("--stdio requires a single SET")
+			}
+
+			_, path := splitName(args[0])
+
+			set, err := openSet(path, cfg.strata)
+			if err != nil {
+				return err
+			}
+
+			res, err := reconcile.Serve(stdio, set)
+			if err != nil {
+				return err
+			}
+
+			if res == nil {
+				fmt.Fprintf(os.Stderr, "Peer ended the session without reconciling.\n")
+
+				return nil
+			}
+
+			// NOTE: The standard output carries the protocol and
+			// the standard error usually ends up in the terminal
+			// of the peer, so the difference is only listed in the
+			// file given with --output.
+			if cfg.output == "" {
+				fmt.Fprintf(os.Stderr, "Reconciled using size %d. The peer lacks %d elements and this side lacks %d.\n",
+					res.Size, len(res.Difference.Left), len(res.Difference.Right))
+
+				return nil
+			}
+
+			return writeResult(os.Stderr, path, set, res)
+		}
+
+		server := reconcile.NewServer()
+		server.IdleTimeout = cfg.idleTimeout
+
+		for _, arg := range args {
+			name, path := splitName(arg)
+
+			set, err := openSet(path, cfg.strata)
+			if err != nil {
+				return err
+			}
+
+			server.Add(name, set)
+		}
+
+		server.OnResult = func(name string, res *reconcile.Result) {
+			fmt.Fprintf(os.Stderr, "%q: Reconciled using size %d. The peer lacks %d elements and this side lacks %d.\n",
+				name, res.Size, len(res.Difference.Left), len(res.Difference.Right))
+		}
+
+		server.OnError = func(name string, err error) {
+			fmt.Fprintf(os.Stderr, "%q: %v\n", name, err)
+		}
+
+		l, err := reconcile.Listen(cfg.listen)
+		if err != nil {
+			return err
+		}
+
+		// NOTE: Closing the server on a signal also removes the unix
+		// socket.
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+		go func() {
+			<-signals
+			_ = server.Close()
+		}()
+
+		fmt.Fprintf(os.Stderr, "Serving %s on %s.\n", strings.Join(server.GetNames(), ", "), cfg.listen)
+
+		return server.Serve(l)
+	},
+}
+
+// splitName splits NAME=PATH. Without a name the base name of the path is
+// used.
+func splitName(arg string) (name, path string) {
+	j := strings.Index(arg, "=")
+	if j < 0 {
+		return filepath.Base(arg), arg
+	}
+
+	return arg[:j], arg[j+1:]
+}
+
+func init() {
+	addPeerFlags(serveCmd)
+
+	serveCmd.Flags().StringVar(&cfg.listen, "listen", "", "Serve concurrent peers on ADDR, given as unix:PATH or tcp:HOST:PORT.")
+	serveCmd.Flags().DurationVar(&cfg.idleTimeout, "idle-timeout", 5*time.Minute, "With --listen, drop peers that send or accept nothing for DURATION. Zero waits forever.")
+
+	RootCmd.AddCommand(serveCmd)
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"strconv"
+
+	ibf "github.com/calebcase/ibf/lib"
+	"github.com/spf13/cobra"
+)
+
+var strataCmd = &cobra.Command{
+	Use:   "strata PATH [SEED]",
+	Short: "Create a new strata estimator. Optionally specify a seed for the hash parameters.",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var path = args[0]
+		var seed int64 = 0
+
+		if len(args) > 1 {
+			seed, err = strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				return err
+			}
+		}
+
+		strata := ibf.NewStrata(seed)
+
+		return create(path, strata)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(strataCmd)
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	ibf "github.com/calebcase/ibf/lib"
 	"github.com/spf13/cobra"
+	"github.com/zeebo/errs"
 )
 
 var subtractCmd = &cobra.Command{
@@ -13,25 +14,49 @@ var subtractCmd = &cobra.Command{
 		paths := args
 		sets := [2]*ibf.IBF{}
 
-		for i, path := range paths {
-			if i > 1 {
-				break
+		var output string
+
+		if len(args) == 2 {
+			output = paths[0]
+		} else {
+			output = paths[2]
+		}
+
+		v, err := load(paths[0])
+		if err != nil {
+			return err
+		}
+
+		bundle, ok := v.(*ibf.Bundle)
+		if ok {
+			other, err := openBundle(paths[1])
+			if err != nil {
+				return err
 			}
 
-			sets[i], err = open(path)
+			err = bundle.Subtract(other)
 			if err != nil {
 				return err
 			}
+
+			return create(output, bundle)
 		}
 
-		sets[0].Subtract(sets[1])
+		// NOTE: The first IBF was already loaded to find out what
+		// the file holds.
+		sets[0], ok = v.(*ibf.IBF)
+		if !ok {
+			return errs.New("%s: not an IBF", paths[0])
+		}
 
-		var output string
+		sets[1], err = open(paths[1])
+		if err != nil {
+			return err
+		}
 
-		if len(args) == 2 {
-			output = paths[0]
-		} else {
-			output = paths[2]
+		err = sets[0].Subtract(sets[1])
+		if err != nil {
+			return err
 		}
 
 		return create(output, sets[0])
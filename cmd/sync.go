@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	ibf "github.com/calebcase/ibf/lib"
+	"github.com/calebcase/ibf/reconcile"
+	"github.com/spf13/cobra"
+	"github.com/zeebo/errs"
+)
+
+// stdio is the byte stream of the standard input and output.
+var stdio = struct {
+	io.Reader
+	io.Writer
+}{os.Stdin, os.Stdout}
+
+var syncCmd = &cobra.Command{
+	Use:   "sync SET",
+	Short: "Reconcile SET with a peer running serve. Elements only in SET are listed in the first column and elements only the peer has in the second.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		err = checkModes(map[string]bool{
+			"--stdio":   cfg.stdio,
+			"--connect": cfg.connect != "",
+			"--dial":    cfg.dial != "",
+		})
+		if err != nil {
+			return err
+		}
+
+		set, err := openSet(args[0], cfg.strata)
+		if err != nil {
+			return err
+		}
+
+		res, err := withClient(func(client *reconcile.Client) (*reconcile.Result, error) {
+			return client.Sync(set)
+		})
+		if err != nil {
+			return err
+		}
+
+		// NOTE: With --stdio the standard output carries the
+		// protocol, so the difference goes to the standard error.
+		out := os.Stdout
+		if cfg.stdio {
+			out = os.Stderr
+		}
+
+		return writeResult(out, args[0], set, res)
+	},
+}
+
+// checkModes returns an error unless exactly one of the flags is set.
+func checkModes(flags map[string]bool) error {
+	var names []string
+
+	set := 0
+	for name, ok := range flags {
+		names = append(names, name)
+
+		if ok {
+			set++
+		}
+	}
+
+	if set != 1 {
+		sort.Strings(names)
+
+		return errs.New("exactly one of %s is required", strings.Join(names, ", "))
+	}
+
+	return nil
+}
+
+// withClient starts a session with the peer selected by --stdio, --connect or
+// --dial and calls fn with it.
+func withClient(fn func(client *reconcile.Client) (*reconcile.Result, error)) (res *reconcile.Result, err error) {
+	switch {
+	case cfg.stdio:
+		client, err := reconcile.NewClient(stdio, cfg.name)
+		if err != nil {
+			return nil, err
+		}
+
+		return fn(client)
+	case cfg.connect != "":
+		return withCommand(cfg.connect, func(rw io.ReadWriter) (*reconcile.Result, error) {
+			client, err := reconcile.NewClient(rw, cfg.name)
+			if err != nil {
+				return nil, err
+			}
+
+			return fn(client)
+		})
+	}
+
+	client, err := reconcile.Dial(cfg.dial, cfg.name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = errs.Combine(err, client.Close())
+	}()
+
+	return fn(client)
+}
+
+// withCommand runs the command with the shell and calls fn with the stream of
+// its standard input and output.
+func withCommand(command string, fn func(rw io.ReadWriter) (*reconcile.Result, error)) (res *reconcile.Result, err error) {
+	c := exec.Command("sh", "-c", command)
+	c.Stderr = os.Stderr
+
+	in, err := c.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := c.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err = fn(struct {
+		io.Reader
+		io.Writer
+	}{out, in})
+
+	err = errs.Combine(err, in.Close())
+
+	return res, errs.Combine(err, c.Wait())
+}
+
+// writeResult writes the difference in the two column format of comm to the
+// output file, if set, or to out. Hashed keys are resolved using the index of
+// the set at path.
+func writeResult(out *os.File, path string, set *reconcile.Set, res *reconcile.Result) (err error) {
+	diff := res.Difference
+
+	if hashesKeys(setFilter(set)) {
+		err = resolve(diff, append([]string{indexPath(path)}, cfg.indexes...))
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.output != "" {
+		out, err = os.Create(cfg.output)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			err = errs.Combine(err, out.Close())
+		}()
+	}
+
+	fmt.Fprintf(os.Stderr, "Reconciled using size %d.\n", res.Size)
+
+	return writeDifference(out, diff)
+}
+
+// writeDifference writes the difference in the two column format of comm.
+func writeDifference(w io.Writer, diff *ibf.Difference) (err error) {
+	if !cfg.suppressLeft {
+		for _, val := range diff.Left {
+			_, err = fmt.Fprintf(w, "%s\n", val)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if !cfg.suppressRight {
+		for _, val := range diff.Right {
+			_, err = fmt.Fprintf(w, "%s%s\n", cfg.columnDelimiter, val)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// addClientFlags adds the flags selecting the peer of a client.
+func addClientFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&cfg.connect, "connect", "", "Run CMD with the shell and speak the protocol over its standard input and output.")
+	cmd.Flags().StringVar(&cfg.dial, "dial", "", "Connect to the server listening on ADDR, given as unix:PATH or tcp:HOST:PORT.")
+	cmd.Flags().StringVar(&cfg.name, "name", "", "Reconcile with the set NAME of the server. Required if it serves more than one.")
+}
+
+// addPeerFlags adds the flags shared by sync and serve.
+func addPeerFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&cfg.stdio, "stdio", false, "Speak the protocol over the standard input and output.")
+	cmd.Flags().StringVar(&cfg.strata, "strata", "", "Estimate the difference using the strata estimator in FILE.")
+	cmd.Flags().StringVarP(&cfg.output, "output", "o", "", "Write the difference to FILE.")
+
+	cmd.Flags().StringVarP(&cfg.columnDelimiter, "output-delimiter", "d", "\t", "Separate columns with STR.")
+	cmd.Flags().BoolVarP(&cfg.suppressLeft, "left", "1", false, "Suppress values only in SET.")
+	cmd.Flags().BoolVarP(&cfg.suppressRight, "right", "2", false, "Suppress values only the peer has.")
+
+	cmd.Flags().StringSliceVar(&cfg.indexes, "index", nil, "Resolve hashed keys using these indexes in addition to SET.index.")
+}
+
+func init() {
+	addPeerFlags(syncCmd)
+
+	addClientFlags(syncCmd)
+
+	RootCmd.AddCommand(syncCmd)
+}
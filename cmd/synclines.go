@@ -0,0 +1,278 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	ibf "github.com/calebcase/ibf/lib"
+	"github.com/calebcase/ibf/reconcile"
+	"github.com/spf13/cobra"
+	"github.com/zeebo/errs"
+)
+
+var syncLinesCmd = &cobra.Command{
+	Use:   "sync-lines LOCAL.txt SET",
+	Short: "Reconcile the lines of LOCAL.txt with a peer and append the lines only the peer has. SET is the IBF or bundle of LOCAL.txt and is updated in the same pass.",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		linesPath, setPath := args[0], args[1]
+
+		err = checkModes(map[string]bool{
+			"--stdio":   cfg.stdio,
+			"--connect": cfg.connect != "",
+			"--dial":    cfg.dial != "",
+			"--listen":  cfg.listen != "",
+		})
+		if err != nil {
+			return err
+		}
+
+		if cfg.serve && !cfg.stdio {
+			return errs.New("--serve requires --stdio")
+		}
+
+		set, err := openSet(setPath, cfg.strata)
+		if err != nil {
+			return err
+		}
+
+		set.Resolve = func(keys [][]byte) ([][]byte, error) {
+			return resolveLines(linesPath, set, keys)
+		}
+
+		if cfg.listen != "" {
+			return serveLines(cfg.listen, linesPath, setPath, set)
+		}
+
+		var res *reconcile.Result
+
+		if cfg.stdio && cfg.serve {
+			res, err = reconcile.Serve(stdio, set)
+		} else {
+			res, err = withClient(func(client *reconcile.Client) (*reconcile.Result, error) {
+				return client.Transfer(set)
+			})
+		}
+		if err != nil {
+			return err
+		}
+
+		if res == nil {
+			fmt.Fprintf(os.Stderr, "Peer ended the session without reconciling.\n")
+
+			return nil
+		}
+
+		return applyLines(linesPath, setPath, set, res)
+	},
+}
+
+// serveLines answers peers on the address one at a time, applying the lines
+// received from each before accepting the next.
+func serveLines(addr, linesPath, setPath string, set *reconcile.Set) (err error) {
+	l, err := reconcile.Listen(addr)
+	if err != nil {
+		return err
+	}
+
+	closed := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+		close(closed)
+		_ = l.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "Serving %s on %s.\n", linesPath, addr)
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			select {
+			case <-closed:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		// NOTE: Sessions are served one at a time since each one
+		// updates the set served to the next.
+		res, err := reconcile.Serve(nc, set)
+		err = errs.Combine(err, nc.Close())
+
+		if err == nil && res != nil {
+			err = applyLines(linesPath, setPath, set, res)
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+}
+
+// resolveLines returns the lines of the file at path for the keys of the set.
+// Sets that hash their keys store the KeyDigest of each line.
+func resolveLines(path string, set *reconcile.Set, keys [][]byte) (lines [][]byte, err error) {
+	if !hashesKeys(setFilter(set)) {
+		return keys, nil
+	}
+
+	wanted := map[string]bool{}
+	for _, key := range keys {
+		wanted[string(key)] = true
+	}
+
+	err = readKeys(path, func(line []byte) error {
+		digest := string(ibf.KeyDigest(line))
+
+		if wanted[digest] {
+			lines = append(lines, copyLine(line))
+			delete(wanted, digest)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wanted) > 0 {
+		return nil, errs.New("%s: %d elements of the difference are missing", path, len(wanted))
+	}
+
+	return lines, nil
+}
+
+// applyLines appends the lines received from the peer to the file at
+// linesPath and inserts them into the set, its estimator and its index before
+// writing the set back to setPath.
+func applyLines(linesPath, setPath string, set *reconcile.Set, res *reconcile.Result) (err error) {
+	// NOTE: The received lines were checked against the difference by
+	// the reconciliation, but still must fit on a line of the file.
+	for _, line := range res.Received {
+		if len(line) > maxLineSize || bytes.IndexByte(line, '\n') >= 0 {
+			return errs.New("received an element that is not a single line")
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Reconciled using size %d. Sent %d lines and received %d.\n",
+		res.Size, len(res.Difference.Left), len(res.Received))
+
+	if len(res.Received) == 0 {
+		return nil
+	}
+
+	err = appendLines(linesPath, res.Received)
+	if err != nil {
+		return err
+	}
+
+	f := setFilter(set)
+
+	var index *indexWriter
+
+	if hashesKeys(f) {
+		index, err = openIndex(indexPath(setPath))
+		if err != nil {
+			return err
+		}
+		defer func() {
+			err = errs.Combine(err, index.Close())
+		}()
+	}
+
+	for _, line := range res.Received {
+		err = f.Insert(line)
+		if err != nil {
+			return err
+		}
+
+		// NOTE: A line is only indexed once it is in the set.
+		if index != nil {
+			err = index.Add(line)
+			if err != nil {
+				return err
+			}
+		}
+
+		if set.Strata != nil {
+			err = set.Strata.Insert(line)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if set.Strata != nil {
+		err = create(cfg.strata, set.Strata)
+		if err != nil {
+			return err
+		}
+	}
+
+	return create(setPath, f)
+}
+
+// appendLines appends the lines to the file at path, ending its last line
+// first if needed.
+func appendLines(path string, lines [][]byte) (err error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errs.Combine(err, file.Close())
+	}()
+
+	end, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+
+	if end > 0 {
+		last := make([]byte, 1)
+
+		_, err = file.ReadAt(last, end-1)
+		if err != nil {
+			return err
+		}
+
+		if last[0] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+
+	for _, line := range lines {
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	_, err = file.Write(buf.Bytes())
+
+	return err
+}
+
+// copyLine returns a copy of the line read by a scanner.
+func copyLine(line []byte) []byte {
+	return append([]byte{}, line...)
+}
+
+func init() {
+	syncLinesCmd.Flags().BoolVar(&cfg.stdio, "stdio", false, "Speak the protocol over the standard input and output.")
+	syncLinesCmd.Flags().BoolVar(&cfg.serve, "serve", false, "Answer the peer instead of starting the reconciliation (with --stdio).")
+	syncLinesCmd.Flags().StringVar(&cfg.listen, "listen", "", "Answer peers one at a time on ADDR, given as unix:PATH or tcp:HOST:PORT.")
+	syncLinesCmd.Flags().StringVar(&cfg.strata, "strata", "", "Estimate the difference using the strata estimator in FILE, which is updated too.")
+
+	addClientFlags(syncLinesCmd)
+
+	RootCmd.AddCommand(syncLinesCmd)
+}
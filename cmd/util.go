@@ -1,14 +1,37 @@
 package cmd
 
 import (
+	"bufio"
+	"bytes"
+	"encoding"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
 
 	ibf "github.com/calebcase/ibf/lib"
+	"github.com/calebcase/ibf/reconcile"
 	"github.com/zeebo/errs"
 )
 
-func create(path string, set *ibf.IBF) (err error) {
+// maxLineSize is the longest line that can be read from stdin.
+const maxLineSize = 1 << 30
+
+// filter is implemented by the file types elements can be inserted into and
+// removed from.
+type filter interface {
+	encoding.BinaryMarshaler
+
+	Insert(key []byte) error
+	Remove(key []byte) error
+}
+
+func create(path string, v encoding.BinaryMarshaler) (err error) {
+	data, err := v.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
 	file, err := os.Create(path)
 	if err != nil {
 		return err
@@ -17,19 +40,244 @@ func create(path string, set *ibf.IBF) (err error) {
 		err = errs.Combine(err, file.Close())
 	}()
 
-	return json.NewEncoder(file).Encode(set)
+	_, err = file.Write(data)
+
+	return err
+}
+
+// load reads the file at path and returns an *ibf.IBF, an *ibf.Strata, an
+// *ibf.IBLT or an *ibf.Bundle depending on its contents. Files are normally in the binary
+// format, but JSON files written by earlier versions are still accepted.
+func load(path string) (v interface{}, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if ibf.IsBinary(data) {
+		return ibf.Unmarshal(data)
+	}
+
+	var probe struct {
+		Strata json.RawMessage `json:"strata"`
+	}
+
+	err = json.Unmarshal(data, &probe)
+	if err != nil {
+		return nil, err
+	}
+
+	if probe.Strata != nil {
+		v = &ibf.Strata{}
+	} else {
+		v = &ibf.IBF{}
+	}
+
+	return v, json.Unmarshal(data, v)
 }
 
 func open(path string) (set *ibf.IBF, err error) {
-	file, err := os.Open(path)
+	v, err := load(path)
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		err = errs.Combine(err, file.Close())
-	}()
 
-	set = &ibf.IBF{}
+	set, ok := v.(*ibf.IBF)
+	if !ok {
+		return nil, errs.New("%s: not an IBF", path)
+	}
+
+	return set, nil
+}
+
+func openStrata(path string) (strata *ibf.Strata, err error) {
+	v, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	strata, ok := v.(*ibf.Strata)
+	if !ok {
+		return nil, errs.New("%s: not a strata estimator", path)
+	}
+
+	return strata, nil
+}
+
+func openBundle(path string) (bundle *ibf.Bundle, err error) {
+	v, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle, ok := v.(*ibf.Bundle)
+	if !ok {
+		return nil, errs.New("%s: not a bundle", path)
+	}
+
+	return bundle, nil
+}
+
+func openTable(path string) (t *ibf.IBLT, err error) {
+	v, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	t, ok := v.(*ibf.IBLT)
+	if !ok {
+		return nil, errs.New("%s: not an IBLT", path)
+	}
+
+	return t, nil
+}
+
+// openSet opens the IBF or bundle at path for reconciliation along with the
+// strata estimator at strataPath, if any.
+func openSet(path, strataPath string) (set *reconcile.Set, err error) {
+	v, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	set, err = reconcile.NewSet(v)
+	if err != nil {
+		return nil, errs.New("%s: %v", path, err)
+	}
+
+	if strataPath != "" {
+		set.Strata, err = openStrata(strataPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return set, nil
+}
+
+// setFilter returns the IBF or bundle backing the set.
+func setFilter(set *reconcile.Set) filter {
+	if set.Bundle != nil {
+		return set.Bundle
+	}
+
+	return set.IBF
+}
+
+func openFilter(path string) (f filter, err error) {
+	v, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if t, ok := v.(*ibf.IBLT); ok {
+		return table{t}, nil
+	}
+
+	return v.(filter), nil
+}
+
+// hashesKeys returns true if the filter stores the KeyDigest of its elements.
+func hashesKeys(f filter) bool {
+	switch f := f.(type) {
+	case *ibf.IBF:
+		return f.HashKeys
+	case *ibf.Bundle:
+		return f.Members[0].HashKeys
+	}
+
+	return false
+}
+
+// table adapts an IBLT to the filter interface. Each element is a key and a
+// value joined by the separator.
+type table struct {
+	*ibf.IBLT
+}
+
+// split separates the element into its key and value. Elements without the
+// separator have an empty value.
+func split(element []byte) (key, value []byte) {
+	j := bytes.Index(element, []byte(cfg.separator))
+	if j < 0 || cfg.separator == "" {
+		return element, nil
+	}
+
+	return element[:j], element[j+len(cfg.separator):]
+}
+
+func (t table) Insert(element []byte) error {
+	key, value := split(element)
+
+	return t.Put(key, value)
+}
+
+func (t table) Remove(element []byte) error {
+	key, value := split(element)
+
+	return t.Delete(key, value)
+}
+
+// readKeys calls fn with each line of the file at path, or of stdin if path is
+// "-".
+func readKeys(path string, fn func(key []byte) error) (err error) {
+	in := os.Stdin
+	if path != "-" {
+		in, err = os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			err = errs.Combine(err, in.Close())
+		}()
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, maxLineSize)
+
+	for scanner.Scan() {
+		err = fn(scanner.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// incomplete reports which sides of the difference could not be completely
+// listed and whether keys were withheld because they failed verification.
+func incomplete(diff *ibf.Difference, err error) {
+	incompleteCells(diff.Remaining, err)
+}
+
+// incompleteCells reports which sides of the remaining cells could not be
+// listed.
+func incompleteCells(remaining []*ibf.Cell, err error) {
+	if err == ibf.ErrSuspiciousKey {
+		fmt.Fprintf(os.Stderr, "Withheld keys that do not hash to the cells they were found in (digest collision).\n")
+	}
+
+	left, right := false, false
+
+	for _, cell := range remaining {
+		switch {
+		case cell.GetCount() > 0:
+			left = true
+		case cell.GetCount() < 0:
+			right = true
+		}
+	}
+
+	side := ""
+	switch {
+	case left && !right:
+		side = "left"
+	case !left && right:
+		side = "right"
+	default:
+		side = "left and right"
+	}
 
-	return set, json.NewDecoder(file).Decode(set)
+	fmt.Fprintf(os.Stderr, "Unable to list all elements (%s).\n", side)
 }
@@ -3,6 +3,7 @@ module github.com/calebcase/ibf
 go 1.13
 
 require (
+	github.com/cespare/xxhash/v2 v2.3.0
 	github.com/davecgh/go-spew v1.1.1
 	github.com/dchest/siphash v1.2.1
 	github.com/go-faster/xor v0.3.0
@@ -11,5 +12,6 @@ require (
 	github.com/spf13/viper v1.5.0
 	github.com/stretchr/testify v1.2.2
 	github.com/zeebo/errs v1.2.2
+	github.com/zeebo/xxh3 v1.0.2
 	golang.org/x/crypto v0.0.0-20191108234033-bd318be0434a
 )
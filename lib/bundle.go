@@ -0,0 +1,294 @@
+package ibf
+
+import (
+	"sort"
+)
+
+// Bundle holds several IBFs of different sizes sharing the same hash
+// parameters. Every key is inserted into all of them, hashing it only once,
+// so a difference can later be decoded from the smallest member large enough
+// for it.
+type Bundle struct {
+	// Members holds the IBFs ordered from smallest to largest.
+	Members []*IBF `json:"members"`
+}
+
+// NewBundle creates a new bundle with one IBF of each size configured by the
+// options. The hash parameters are created using the output from a random
+// number generator initialized with the seed, so each member is the IBF that
+// NewIBFWithOptions would create for its size.
+func NewBundle(sizes []uint64, seed int64, opts Options) (*Bundle, error) {
+	if len(sizes) == 0 {
+		return nil, Error.New("a bundle needs at least one size")
+	}
+
+	sizes = append([]uint64{}, sizes...)
+	sort.Slice(sizes, func(a, b int) bool { return sizes[a] < sizes[b] })
+
+	members := make([]*IBF, len(sizes))
+	for j, size := range sizes {
+		if j > 0 && size == sizes[j-1] {
+			return nil, Error.New("duplicate size %d", size)
+		}
+
+		set, err := NewIBFWithOptions(size, seed, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		members[j] = set
+	}
+
+	return &Bundle{
+		Members: members,
+	}, nil
+}
+
+// Insert adds the key to every member. If the members hash their keys the
+// KeyDigest of the key is added instead.
+func (b *Bundle) Insert(key []byte) error {
+	if b.Members[0].HashKeys {
+		key = KeyDigest(key)
+	}
+
+	return b.update(key, 1)
+}
+
+// Remove deletes the key from every member. If the members hash their keys
+// the KeyDigest of the key is deleted instead.
+func (b *Bundle) Remove(key []byte) error {
+	if b.Members[0].HashKeys {
+		key = KeyDigest(key)
+	}
+
+	return b.update(key, -1)
+}
+
+// update adds (sign 1) or removes (sign -1) the key from every member.
+func (b *Bundle) update(key []byte, sign int64) error {
+	first := b.Members[0]
+
+	err := first.checkKey(key)
+	if err != nil {
+		return err
+	}
+
+	var buf, indexBuf [maxStackHashes]uint64
+
+	// NOTE: The members share their hash parameters, so the key is only
+	// hashed once and then placed in each member.
+	digest, hashes := first.hashKey(key, buf[:])
+
+	indices := indexBuf[:0]
+	if cap(indices) < len(hashes) {
+		indices = make([]uint64, 0, len(hashes))
+	}
+
+	for _, set := range b.Members {
+		indices = append(indices[:0], hashes...)
+
+		for _, index := range set.place(indices) {
+			switch {
+			case set.Multiset:
+				set.cells.add(index, key, digest, sign)
+			case sign > 0:
+				set.cells.insert(index, key, digest)
+			default:
+				set.cells.remove(index, key, digest)
+			}
+		}
+
+		set.Cardinality += sign
+	}
+
+	return nil
+}
+
+// Union adds the members of the other bundle to the members of this one. If
+// the bundles don't have the same sizes and parameters it returns an
+// ErrIncompatible error and leaves this bundle unchanged.
+func (b *Bundle) Union(other *Bundle) error {
+	err := b.Compatible(other)
+	if err != nil {
+		return err
+	}
+
+	for j, set := range b.Members {
+		err = set.Union(other.Members[j])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Subtract subtracts the members of the other bundle from the members of this
+// one. If the bundles don't have the same sizes and parameters it returns an
+// ErrIncompatible error and leaves this bundle unchanged.
+func (b *Bundle) Subtract(other *Bundle) error {
+	err := b.Compatible(other)
+	if err != nil {
+		return err
+	}
+
+	for j, set := range b.Members {
+		err = set.Subtract(other.Members[j])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Compatible returns an ErrIncompatible error naming the first parameter that
+// differs between the two bundles.
+func (b *Bundle) Compatible(other *Bundle) error {
+	if len(b.Members) != len(other.Members) {
+		return ErrIncompatible.New("member count %d != %d", len(b.Members), len(other.Members))
+	}
+
+	for j := range b.Members {
+		err := b.Members[j].Compatible(other.Members[j])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Decode lists the bundle using the smallest member that decodes completely.
+// It returns the difference and the member used. The bundle is left
+// unchanged. If no member decodes completely it returns the partial
+// difference of the largest member along with its error.
+func (b *Bundle) Decode() (diff *Difference, member *IBF, err error) {
+	for _, set := range b.Members {
+		member = set
+
+		diff, err = set.Clone().Decode()
+		if err == nil {
+			return diff, member, nil
+		}
+	}
+
+	return diff, member, err
+}
+
+// Clone returns a copy of this bundle.
+func (b *Bundle) Clone() *Bundle {
+	members := make([]*IBF, len(b.Members))
+	for j, set := range b.Members {
+		members[j] = set.Clone()
+	}
+
+	return &Bundle{
+		Members: members,
+	}
+}
+
+// GetSizes returns the sizes of the members.
+func (b *Bundle) GetSizes() []uint64 {
+	sizes := make([]uint64, len(b.Members))
+	for j, set := range b.Members {
+		sizes[j] = set.Size
+	}
+
+	return sizes
+}
+
+// GetFingerprint returns the fingerprint of the member of the size.
+func (b *Bundle) GetFingerprint(size uint64) uint64 {
+	return b.Members[0].GetFingerprint(size)
+}
+
+// GetIBF returns a copy of the member of the size.
+func (b *Bundle) GetIBF(size uint64) (*IBF, error) {
+	for _, set := range b.Members {
+		if set.Size == size {
+			return set.Clone(), nil
+		}
+	}
+
+	return nil, Error.New("no member of size %d", size)
+}
+
+// MarshalBinary encodes the bundle in the compact binary format.
+func (b *Bundle) MarshalBinary() (data []byte, err error) {
+	e := newEncoder(kindBundle)
+
+	e.uvarint(uint64(len(b.Members)))
+
+	for _, set := range b.Members {
+		data, err := set.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+
+		e.bytes(data)
+	}
+
+	return e.buf, nil
+}
+
+// UnmarshalBinary decodes a bundle encoded by MarshalBinary.
+func (b *Bundle) UnmarshalBinary(data []byte) (err error) {
+	kind, data, err := decodeHeader(data)
+	if err != nil {
+		return err
+	}
+
+	if kind != kindBundle {
+		return Error.New("not a bundle")
+	}
+
+	d := &decoder{data: data}
+
+	count := d.uvarint()
+
+	if d.err != nil {
+		return d.err
+	}
+
+	if count == 0 || count > uint64(len(d.data)) {
+		return Error.New("invalid member count %d", count)
+	}
+
+	members := make([]*IBF, count)
+	for j := range members {
+		members[j] = &IBF{}
+
+		err = members[j].UnmarshalBinary(d.bytes())
+		if d.err != nil {
+			return d.err
+		}
+		if err != nil {
+			return err
+		}
+
+		// NOTE: Members must share their hash parameters and be
+		// ordered by size for Insert and Decode to work.
+		if j > 0 {
+			if members[j].Size <= members[j-1].Size {
+				return Error.New("members out of order")
+			}
+
+			err = members[0].compatibleParams(members[j])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = d.done()
+	if err != nil {
+		return err
+	}
+
+	*b = Bundle{
+		Members: members,
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package ibf
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundle(t *testing.T) {
+	t.Run("decode", func(t *testing.T) {
+		opts := DefaultOptions
+
+		b0, err := NewBundle([]uint64{200, 20, 60}, 1, opts)
+		require.NoError(t, err)
+		require.Equal(t, []uint64{20, 60, 200}, b0.GetSizes())
+
+		b1 := b0.Clone()
+
+		for j := 0; j < 1000; j++ {
+			require.NoError(t, b0.Insert([]byte(strconv.Itoa(j))))
+		}
+		for j := 30; j < 1000; j++ {
+			require.NoError(t, b1.Insert([]byte(strconv.Itoa(j))))
+		}
+
+		// Every member is the IBF that would have been built on its
+		// own.
+		for _, size := range b0.GetSizes() {
+			set, err := NewIBFWithOptions(size, 1, opts)
+			require.NoError(t, err)
+
+			for j := 0; j < 1000; j++ {
+				require.NoError(t, set.Insert([]byte(strconv.Itoa(j))))
+			}
+
+			require.Contains(t, b0.Members, set)
+		}
+
+		require.NoError(t, b0.Subtract(b1))
+
+		diff, member, err := b0.Decode()
+		require.NoError(t, err)
+		require.Equal(t, uint64(60), member.Size)
+		require.Len(t, diff.Left, 30)
+
+		// Decoding leaves the bundle unchanged.
+		diff, member, err = b0.Decode()
+		require.NoError(t, err)
+		require.Equal(t, uint64(60), member.Size)
+		require.Len(t, diff.Left, 30)
+
+		// Too large a difference fails with the largest member.
+		for j := 1000; j < 2000; j++ {
+			require.NoError(t, b0.Insert([]byte(strconv.Itoa(j))))
+		}
+
+		_, member, err = b0.Decode()
+		require.Equal(t, ErrNoPureCell, err)
+		require.Equal(t, uint64(200), member.Size)
+	})
+
+	t.Run("options", func(t *testing.T) {
+		_, err := NewBundle(nil, 1, DefaultOptions)
+		require.Error(t, err)
+
+		_, err = NewBundle([]uint64{20, 20}, 1, DefaultOptions)
+		require.Error(t, err)
+
+		_, err = NewBundle([]uint64{2, 20}, 1, DefaultOptions)
+		require.Error(t, err)
+
+		b0, err := NewBundle([]uint64{30, 60}, 1, Options{Hashes: 3, HashKeys: true, Multiset: true})
+		require.NoError(t, err)
+
+		require.NoError(t, b0.Insert([]byte("a")))
+		require.NoError(t, b0.Insert([]byte("a")))
+
+		diff, member, err := b0.Decode()
+		require.NoError(t, err)
+		require.Equal(t, uint64(30), member.Size)
+		require.Equal(t, [][]byte{KeyDigest([]byte("a"))}, diff.Left)
+		require.Equal(t, []int64{2}, diff.LeftCounts)
+
+		b1, err := NewBundle([]uint64{30, 60}, 2, Options{Hashes: 3, HashKeys: true, Multiset: true})
+		require.NoError(t, err)
+		require.True(t, ErrIncompatible.Has(b0.Union(b1)))
+
+		b2, err := NewBundle([]uint64{30}, 1, Options{Hashes: 3, HashKeys: true, Multiset: true})
+		require.NoError(t, err)
+		require.True(t, ErrIncompatible.Has(b0.Subtract(b2)))
+	})
+
+	t.Run("encoding", func(t *testing.T) {
+		b0, err := NewBundle([]uint64{20, 60}, 1, DefaultOptions)
+		require.NoError(t, err)
+
+		for j := 0; j < 10; j++ {
+			require.NoError(t, b0.Insert([]byte(strconv.Itoa(j))))
+		}
+
+		data, err := b0.MarshalBinary()
+		require.NoError(t, err)
+
+		u, err := Unmarshal(data)
+		require.NoError(t, err)
+		require.Equal(t, b0, u)
+
+		for n := range data {
+			require.Error(t, (&Bundle{}).UnmarshalBinary(data[:n]), "truncated to %d", n)
+		}
+
+		// Members must share their parameters.
+		other := NewIBF(40, 2)
+
+		b1 := &Bundle{Members: []*IBF{b0.Members[0], other}}
+		data, err = b1.MarshalBinary()
+		require.NoError(t, err)
+		require.Error(t, (&Bundle{}).UnmarshalBinary(data))
+
+		b2 := &Bundle{Members: []*IBF{b0.Members[1], b0.Members[0]}}
+		data, err = b2.MarshalBinary()
+		require.NoError(t, err)
+		require.Error(t, (&Bundle{}).UnmarshalBinary(data))
+	})
+}
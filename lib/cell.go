@@ -5,6 +5,10 @@ type Cell struct {
 	Key    *block `json:"key"`
 	Digest uint64 `json:"digest"`
 	Count  int64  `json:"count"`
+
+	// DigestHi holds the upper half of the digest in IBFs using 128-bit
+	// digests. It is always zero otherwise.
+	DigestHi uint64 `json:"digest_hi,omitempty"`
 }
 
 // NewCell returns a new empty cell.
@@ -43,6 +47,7 @@ func (c *Cell) Remove(key []byte, digest uint64) {
 func (c *Cell) Union(cell *Cell) {
 	c.Key.Xor(cell.Key)
 	c.Digest = c.Digest ^ cell.GetDigest()
+	c.DigestHi = c.DigestHi ^ cell.DigestHi
 	c.Count += cell.GetCount()
 }
 
@@ -53,6 +58,7 @@ func (c *Cell) Union(cell *Cell) {
 func (c *Cell) Subtract(cell *Cell) {
 	c.Key.Xor(cell.Key)
 	c.Digest = c.Digest ^ cell.GetDigest()
+	c.DigestHi = c.DigestHi ^ cell.DigestHi
 	c.Count -= cell.GetCount()
 }
 
@@ -67,6 +73,8 @@ func (c *Cell) Clone() *Cell {
 		Key:    c.Key.Clone(),
 		Digest: c.Digest,
 		Count:  c.Count,
+
+		DigestHi: c.DigestHi,
 	}
 }
 
@@ -93,15 +101,53 @@ func (c *Cell) GetCount() int64 {
 // IsEmpty returns true if the cell's count is zero, key is empty, and digest
 // is zero.
 func (c *Cell) IsEmpty() bool {
-	return c.Count == 0 && len(c.Key.Value()) == 0 && c.Digest == 0
+	return c.Count == 0 && len(c.Key.Value()) == 0 && c.Digest == 0 && c.DigestHi == 0
 }
 
 // IsPure returns true if the cell contains exactly one value and the hash is
 // valid.
-func (c *Cell) IsPure(h *Hash) bool {
+//
+// NOTE: Only the 64-bit digest of the value is checked, so the result is only
+// meaningful for IBFs using the independent scheme and 64-bit digests. Use
+// IBF.IsPure to check the cells of any IBF.
+func (c *Cell) IsPure(h Hasher) bool {
 	if c.Count == 1 {
 		return c.Digest == h.Hash(c.Key.Value())
 	}
 
 	return false
 }
+
+// decodeCell consumes the binary encoding of a cell.
+func decodeCell(d *decoder, wide bool) *Cell {
+	var digestHi uint64
+
+	count := d.varint()
+	digest := d.uint64()
+	if wide {
+		digestHi = d.uint64()
+	}
+	data := d.bytes()
+
+	// NOTE: The key always holds at least the length of the value.
+	if d.err == nil && len(data) < 8 {
+		d.fail(Error.New("truncated key"))
+	}
+
+	if d.err != nil {
+		return nil
+	}
+
+	key := &block{
+		Data: make([]byte, len(data)),
+	}
+	copy(key.Data, data)
+
+	return &Cell{
+		Key:    key,
+		Digest: digest,
+		Count:  count,
+
+		DigestHi: digestHi,
+	}
+}
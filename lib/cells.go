@@ -0,0 +1,350 @@
+package ibf
+
+import (
+	"encoding/binary"
+
+	xor "github.com/go-faster/xor"
+)
+
+// sums holds one XOR sum of byte strings per cell in a contiguous array. Sum
+// j occupies data[j*width:(j+1)*width] and is laid out like a block: the big
+// endian length of the value followed by the value. All sums share the same
+// width which only grows when a longer value is added, so updating a sum
+// doesn't allocate. The width doesn't shrink when that value is removed, but
+// sums are encoded without their trailing zeros so a decoded copy is only as
+// wide as the values it still holds.
+//
+// If the sums are fixed all values have the same width and the sums hold just
+// the values without the length.
+type sums struct {
+	data  []byte
+	width int
+	fixed bool
+}
+
+// newSums returns zeroed sums. If valueWidth is zero values of any length can
+// be stored, otherwise the sums are fixed to values of that width.
+func newSums(size uint64, valueWidth int) sums {
+	s := sums{
+		width: 8,
+	}
+
+	if valueWidth > 0 {
+		s.width = valueWidth
+		s.fixed = true
+	}
+
+	s.data = make([]byte, size*uint64(s.width))
+
+	return s
+}
+
+// newSumsFrom copies the blocks into a contiguous array.
+func newSumsFrom(from []*block, valueWidth int) sums {
+	s := newSums(uint64(len(from)), valueWidth)
+
+	if !s.fixed {
+		width := s.width
+		for _, b := range from {
+			if len(b.Data) > width {
+				width = len(b.Data)
+			}
+		}
+		s.reserve(width)
+	}
+
+	for j, b := range from {
+		if s.fixed {
+			copy(s.slot(uint64(j)), b.Data[8:])
+		} else {
+			copy(s.slot(uint64(j)), b.Data)
+		}
+	}
+
+	return s
+}
+
+// len returns the number of sums.
+func (s *sums) len() int {
+	return len(s.data) / s.width
+}
+
+// reserve grows the sums so that each can hold at least n bytes. The width is
+// rounded up to a multiple of 8 to keep the number of times the data is
+// copied low.
+func (s *sums) reserve(n int) {
+	if s.fixed || n <= s.width {
+		return
+	}
+
+	count := s.len()
+	width := (n + 7) &^ 7
+	data := make([]byte, count*width)
+
+	for j := 0; j < count; j++ {
+		copy(data[j*width:], s.data[j*s.width:(j+1)*s.width])
+	}
+
+	s.data = data
+	s.width = width
+}
+
+// slot returns sum j. It aliases the sums' storage.
+func (s *sums) slot(j uint64) []byte {
+	offset := j * uint64(s.width)
+
+	return s.data[offset : offset+uint64(s.width)]
+}
+
+// trimmed returns sum j without its trailing zero bytes, keeping at least the
+// length of the value. Since all sums share the width of the longest value
+// ever added, this is what needs to be stored to restore the sum. It aliases
+// the sums' storage.
+func (s *sums) trimmed(j uint64) []byte {
+	slot := s.slot(j)
+
+	n := len(slot)
+	for n > 8 && slot[n-1] == 0 {
+		n--
+	}
+
+	return slot[:n]
+}
+
+// xor toggles the value in sum j.
+func (s *sums) xor(j uint64, value []byte) {
+	if s.fixed {
+		slot := s.slot(j)
+		xor.Bytes(slot, slot, value)
+
+		return
+	}
+
+	s.reserve(8 + len(value))
+
+	slot := s.slot(j)
+	binary.BigEndian.PutUint64(slot, binary.BigEndian.Uint64(slot)^uint64(len(value)))
+	xor.Bytes(slot[8:], slot[8:], value)
+}
+
+// value returns the value held by sum j assuming it holds exactly one. Like
+// block.Value the result is truncated if the stored length is too large. It
+// aliases the sums' storage.
+func (s *sums) value(j uint64) []byte {
+	slot := s.slot(j)
+	if s.fixed {
+		return slot
+	}
+
+	size := binary.BigEndian.Uint64(slot)
+
+	if size > uint64(len(slot)-8) {
+		size = uint64(len(slot) - 8)
+	}
+
+	return slot[8 : 8+size]
+}
+
+// isZero returns true if sum j is all zero.
+func (s *sums) isZero(j uint64) bool {
+	for _, b := range s.slot(j) {
+		if b != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// block returns a copy of sum j as a block. The count is the number of values
+// combined into the sum.
+func (s *sums) block(j uint64, count int64) *block {
+	var data []byte
+
+	if s.fixed {
+		// NOTE: The sum is presented as the block it would be if the
+		// values had been stored with their lengths. The lengths
+		// cancel out whenever an even number of values was combined.
+		data = make([]byte, 8+s.width)
+		if count&1 == 1 {
+			binary.BigEndian.PutUint64(data, uint64(s.width))
+		}
+		copy(data[8:], s.slot(j))
+	} else {
+		trimmed := s.trimmed(j)
+
+		data = make([]byte, len(trimmed))
+		copy(data, trimmed)
+	}
+
+	return &block{Data: data}
+}
+
+// combine XORs the other sums into these.
+func (s *sums) combine(other *sums) {
+	s.reserve(other.width)
+
+	for j := uint64(0); j < uint64(s.len()); j++ {
+		slot := s.slot(j)
+		xor.Bytes(slot, slot, other.slot(j))
+	}
+}
+
+// xorSlot XORs sum j of the other sums into sum t.
+func (s *sums) xorSlot(t uint64, other *sums, j uint64) {
+	s.reserve(other.width)
+
+	from := other.slot(j)
+	slot := s.slot(t)[:len(from)]
+	xor.Bytes(slot, slot, from)
+}
+
+// setSlot replaces sum t with sum j of the other sums.
+func (s *sums) setSlot(t uint64, other *sums, j uint64) {
+	s.reserve(other.width)
+
+	slot := s.slot(t)
+	for k := range slot {
+		slot[k] = 0
+	}
+	copy(slot, other.slot(j))
+}
+
+// clone returns a deep copy of the sums.
+func (s *sums) clone() sums {
+	clone := *s
+
+	clone.data = make([]byte, len(s.data))
+	copy(clone.data, s.data)
+
+	return clone
+}
+
+// cells holds the state of all the cells of an IBF in contiguous arrays
+// instead of one heap allocated Cell per position.
+type cells struct {
+	counts  []int64
+	digests [][2]uint64
+	keys    sums
+}
+
+// newCells returns empty cells. If keyWidth is zero keys of any length can be
+// stored, otherwise the cells are fixed to keys of that width.
+func newCells(size uint64, keyWidth int) cells {
+	return cells{
+		counts:  make([]int64, size),
+		digests: make([][2]uint64, size),
+		keys:    newSums(size, keyWidth),
+	}
+}
+
+// newCellsFrom copies the cells into contiguous arrays.
+func newCellsFrom(from []*Cell, keyWidth int) cells {
+	blocks := make([]*block, len(from))
+	for j, cell := range from {
+		blocks[j] = cell.Key
+	}
+
+	c := cells{
+		counts:  make([]int64, len(from)),
+		digests: make([][2]uint64, len(from)),
+		keys:    newSumsFrom(blocks, keyWidth),
+	}
+
+	for j, cell := range from {
+		c.counts[j] = cell.Count
+		c.digests[j] = [2]uint64{cell.Digest, cell.DigestHi}
+	}
+
+	return c
+}
+
+// xor toggles the key and digest in cell j.
+func (c *cells) xor(j uint64, key []byte, digest [2]uint64) {
+	c.keys.xor(j, key)
+
+	c.digests[j][0] ^= digest[0]
+	c.digests[j][1] ^= digest[1]
+}
+
+// insert adds the key with the given digest to cell j.
+func (c *cells) insert(j uint64, key []byte, digest [2]uint64) {
+	c.xor(j, key, digest)
+	c.counts[j]++
+}
+
+// remove deletes the key with the given digest from cell j.
+func (c *cells) remove(j uint64, key []byte, digest [2]uint64) {
+	c.xor(j, key, digest)
+	c.counts[j]--
+}
+
+// value returns the key held by cell j assuming it is pure.
+func (c *cells) value(j uint64) []byte {
+	return c.keys.value(j)
+}
+
+// isEmpty returns true if cell j's count, digest and key sum are all zero.
+func (c *cells) isEmpty(j uint64) bool {
+	return c.counts[j] == 0 && c.digests[j] == [2]uint64{} && c.keys.isZero(j)
+}
+
+// cell returns a copy of cell j.
+func (c *cells) cell(j uint64) *Cell {
+	return &Cell{
+		Key:    c.keys.block(j, c.counts[j]),
+		Digest: c.digests[j][0],
+		Count:  c.counts[j],
+
+		DigestHi: c.digests[j][1],
+	}
+}
+
+// combine adds (sign 1) or subtracts (sign -1) the other cells to these.
+func (c *cells) combine(other *cells, sign int64) {
+	c.keys.combine(&other.keys)
+
+	for j := range c.counts {
+		c.counts[j] += sign * other.counts[j]
+		c.digests[j][0] ^= other.digests[j][0]
+		c.digests[j][1] ^= other.digests[j][1]
+	}
+}
+
+// addCell adds cell j of the other cells to cell t.
+func (c *cells) addCell(t uint64, other *cells, j uint64) {
+	c.keys.xorSlot(t, &other.keys, j)
+
+	c.counts[t] += other.counts[j]
+	c.digests[t][0] ^= other.digests[j][0]
+	c.digests[t][1] ^= other.digests[j][1]
+}
+
+// set replaces cell t with cell j of the other cells.
+func (c *cells) set(t uint64, other *cells, j uint64) {
+	c.keys.setSlot(t, &other.keys, j)
+
+	c.counts[t] = other.counts[j]
+	c.digests[t] = other.digests[j]
+}
+
+// invert negates the counts.
+func (c *cells) invert() {
+	for j := range c.counts {
+		c.counts[j] *= -1
+	}
+}
+
+// clone returns a deep copy of the cells.
+func (c *cells) clone() cells {
+	clone := cells{
+		counts:  make([]int64, len(c.counts)),
+		digests: make([][2]uint64, len(c.digests)),
+		keys:    c.keys.clone(),
+	}
+
+	copy(clone.counts, c.counts)
+	copy(clone.digests, c.digests)
+
+	return clone
+}
@@ -0,0 +1,119 @@
+package ibf
+
+import "math/bits"
+
+// Chunker splits data into chunks at boundaries chosen by the content itself
+// (FastCDC). A rolling gear hash is computed over the bytes and a boundary is
+// placed where the hash matches a mask. Since a boundary only depends on the
+// bytes right before it, inserting or removing bytes only moves the
+// boundaries around the edit and the chunks after it are unchanged.
+//
+// Chunks are at least Min and at most Max bytes long. Normalized chunking
+// uses a stricter mask before Avg and a looser one after it so that most
+// chunks end up close to Avg.
+type Chunker struct {
+	Min int
+	Avg int
+	Max int
+
+	maskS uint64
+	maskL uint64
+}
+
+// gear maps each byte to a random value mixed into the rolling hash.
+var gear [256]uint64
+
+func init() {
+	// NOTE: The table must be the same everywhere chunks are compared, so
+	// it is derived from a fixed seed with splitmix64.
+	x := uint64(0x6962662d63646321)
+	for i := range gear {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// NewChunker returns a chunker producing chunks of avg bytes on average. avg
+// must be a power of two of at least 64. Chunks are between avg/4 and avg*8
+// bytes long.
+func NewChunker(avg int) (*Chunker, error) {
+	if avg < 64 || avg&(avg-1) != 0 {
+		return nil, Error.New("average chunk size %d is not a power of two of at least 64", avg)
+	}
+
+	n := bits.TrailingZeros(uint(avg))
+
+	return &Chunker{
+		Min:   avg / 4,
+		Avg:   avg,
+		Max:   avg * 8,
+		maskS: mask(n + 2),
+		maskL: mask(n - 2),
+	}, nil
+}
+
+// mask returns a mask of the n most significant bits. The gear hash shifts
+// left, so its high bits depend on the most bytes.
+func mask(n int) uint64 {
+	return ^uint64(0) << uint(64-n)
+}
+
+// Boundary returns the length of the first chunk of data. If data is shorter
+// than Max and no boundary is found, the whole of data is the chunk.
+func (c *Chunker) Boundary(data []byte) int {
+	if len(data) <= c.Min {
+		return len(data)
+	}
+
+	end := len(data)
+	if end > c.Max {
+		end = c.Max
+	}
+
+	normal := c.Avg
+	if normal > end {
+		normal = end
+	}
+
+	var h uint64
+
+	// NOTE: The hash is not computed over the first Min bytes since no
+	// boundary may be placed there.
+	i := c.Min
+	for ; i < normal; i++ {
+		h = (h << 1) + gear[data[i]]
+		if h&c.maskS == 0 {
+			return i + 1
+		}
+	}
+
+	for ; i < end; i++ {
+		h = (h << 1) + gear[data[i]]
+		if h&c.maskL == 0 {
+			return i + 1
+		}
+	}
+
+	return end
+}
+
+// Split is a bufio.SplitFunc returning one chunk at a time. The buffer of the
+// scanner must hold at least Max bytes.
+func (c *Chunker) Split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	// NOTE: Until Max bytes are buffered a boundary found may only be the
+	// end of the buffer rather than one chosen by the content.
+	if len(data) < c.Max && !atEOF {
+		return 0, nil, nil
+	}
+
+	n := c.Boundary(data)
+
+	return n, data[:n], nil
+}
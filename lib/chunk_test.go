@@ -0,0 +1,94 @@
+package ibf
+
+import (
+	"bufio"
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunker(t *testing.T) {
+	chunks := func(t *testing.T, c *Chunker, data []byte) (chunks []string) {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, c.Max), c.Max)
+		scanner.Split(c.Split)
+
+		for scanner.Scan() {
+			chunks = append(chunks, scanner.Text())
+		}
+		require.NoError(t, scanner.Err())
+
+		return chunks
+	}
+
+	data := make([]byte, 1<<20)
+	_, err := rand.New(rand.NewSource(1)).Read(data)
+	require.NoError(t, err)
+
+	c, err := NewChunker(4096)
+	require.NoError(t, err)
+
+	t.Run("bounds", func(t *testing.T) {
+		all := chunks(t, c, data)
+		require.Equal(t, data, []byte(joinChunks(all)))
+
+		for i, chunk := range all {
+			require.True(t, len(chunk) <= c.Max)
+
+			if i < len(all)-1 {
+				require.True(t, len(chunk) > c.Min)
+			}
+		}
+
+		// Normalized chunking keeps the average close to Avg.
+		avg := len(data) / len(all)
+		require.True(t, avg > c.Avg/2 && avg < c.Avg*2, "average %d", avg)
+	})
+
+	t.Run("shift", func(t *testing.T) {
+		before := chunks(t, c, data)
+
+		edited := append(append(append([]byte{}, data[:500000]...), "inserted"...), data[500000:]...)
+		after := chunks(t, c, edited)
+
+		seen := map[string]bool{}
+		for _, chunk := range before {
+			seen[chunk] = true
+		}
+
+		changed := 0
+		for _, chunk := range after {
+			if !seen[chunk] {
+				changed++
+			}
+		}
+
+		require.True(t, changed >= 1 && changed <= 2, "changed %d", changed)
+	})
+
+	t.Run("zeros", func(t *testing.T) {
+		// Without any boundary every chunk is Max bytes long.
+		all := chunks(t, c, make([]byte, c.Max*2+1))
+		require.Len(t, all, 3)
+		require.Len(t, all[0], c.Max)
+		require.Len(t, all[2], 1)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, avg := range []int{0, 32, 100, 4095} {
+			_, err := NewChunker(avg)
+			require.Error(t, err, avg)
+		}
+	})
+}
+
+func joinChunks(chunks []string) string {
+	var buf bytes.Buffer
+	for _, chunk := range chunks {
+		buf.WriteString(chunk)
+	}
+
+	return buf.String()
+}
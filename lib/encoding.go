@@ -0,0 +1,336 @@
+package ibf
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"sort"
+)
+
+// Every binary encoded value starts with a header made of the magic bytes,
+// a byte identifying the kind of value, and the format version.
+var magic = []byte("\x89IBF")
+
+// Kinds of binary encoded values.
+const (
+	kindIBF     byte = 'F'
+	kindStrata  byte = 'S'
+	kindIBLT    byte = 'T'
+	kindSymbols byte = 'R'
+	kindBundle  byte = 'B'
+	kindMessage byte = 'M'
+	kindPatch   byte = 'P'
+)
+
+// FormatVersion is the version of the binary encoding written by this
+// package.
+const FormatVersion = 1
+
+// Parameter tags used in the parameter section of an encoded IBF.
+const (
+	tagSize        = 1
+	tagPositioners = 2
+	tagHasher      = 3
+	tagPlacement   = 4
+	tagScheme      = 5
+	tagHash        = 6
+	tagDigestBits  = 7
+	tagKeyWidth    = 8
+	tagHashKeys    = 9
+	tagMultiset    = 10
+	tagStart       = 11
+	tagMeta        = 12
+)
+
+// IsBinary returns true if the data starts with the binary encoding header.
+func IsBinary(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
+
+// Unmarshal decodes a value produced by one of the MarshalBinary methods in
+// this package. Depending on the kind of value encoded it returns an *IBF, a
+// *Strata, an *IBLT or a *Bundle.
+func Unmarshal(data []byte) (v interface{}, err error) {
+	kind, _, err := decodeHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var u encoding.BinaryUnmarshaler
+
+	switch kind {
+	case kindIBF:
+		u = &IBF{}
+	case kindStrata:
+		u = &Strata{}
+	case kindIBLT:
+		u = &IBLT{}
+	case kindBundle:
+		u = &Bundle{}
+	default:
+		return nil, Error.New("unknown kind %q", kind)
+	}
+
+	err = u.UnmarshalBinary(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// decodeHeader checks the header and returns the kind of value and the
+// remaining data.
+func decodeHeader(data []byte) (kind byte, rest []byte, err error) {
+	if !IsBinary(data) {
+		return 0, nil, Error.New("missing header")
+	}
+
+	data = data[len(magic):]
+	if len(data) < 2 {
+		return 0, nil, Error.New("truncated header")
+	}
+
+	kind, version := data[0], data[1]
+	if version != FormatVersion {
+		return 0, nil, Error.New("unsupported format version %d", version)
+	}
+
+	return kind, data[2:], nil
+}
+
+// encoder appends binary encoded values to a buffer.
+type encoder struct {
+	buf     []byte
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func newEncoder(kind byte) *encoder {
+	e := &encoder{}
+	e.buf = append(e.buf, magic...)
+	e.buf = append(e.buf, kind, FormatVersion)
+
+	return e
+}
+
+func (e *encoder) uvarint(v uint64) {
+	n := binary.PutUvarint(e.scratch[:], v)
+	e.buf = append(e.buf, e.scratch[:n]...)
+}
+
+func (e *encoder) varint(v int64) {
+	n := binary.PutVarint(e.scratch[:], v)
+	e.buf = append(e.buf, e.scratch[:n]...)
+}
+
+func (e *encoder) uint64(v uint64) {
+	binary.BigEndian.PutUint64(e.scratch[:], v)
+	e.buf = append(e.buf, e.scratch[:8]...)
+}
+
+// raw appends the data as is.
+func (e *encoder) raw(data []byte) {
+	e.buf = append(e.buf, data...)
+}
+
+// bytes appends the length prefixed data.
+func (e *encoder) bytes(data []byte) {
+	e.uvarint(uint64(len(data)))
+	e.buf = append(e.buf, data...)
+}
+
+func (e *encoder) hash(h Hasher) {
+	key := h.GetKey()
+
+	e.uint64(key[0])
+	e.uint64(key[1])
+}
+
+// params appends the parameter section. The section is length prefixed so
+// that it can be skipped or checked as a whole.
+func (e *encoder) params(fn func(e *encoder)) {
+	section := &encoder{}
+	fn(section)
+
+	e.bytes(section.buf)
+}
+
+// param appends a tagged parameter whose value is produced by fn.
+func (e *encoder) param(tag uint64, fn func(e *encoder)) {
+	value := &encoder{}
+	fn(value)
+
+	e.uvarint(tag)
+	e.bytes(value.buf)
+}
+
+// meta appends the annotations as their count followed by each key and value
+// ordered by key.
+func (e *encoder) meta(m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	e.uvarint(uint64(len(keys)))
+
+	for _, k := range keys {
+		e.bytes([]byte(k))
+		e.bytes([]byte(m[k]))
+	}
+}
+
+// decoder consumes binary encoded values from a buffer. The first error
+// encountered is kept and all following reads return zero values.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+
+	d.data = nil
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail(Error.New("invalid uvarint"))
+
+		return 0
+	}
+
+	d.data = d.data[n:]
+
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail(Error.New("invalid varint"))
+
+		return 0
+	}
+
+	d.data = d.data[n:]
+
+	return v
+}
+
+func (d *decoder) uint64() uint64 {
+	if len(d.data) < 8 {
+		d.fail(Error.New("truncated uint64"))
+
+		return 0
+	}
+
+	v := binary.BigEndian.Uint64(d.data)
+	d.data = d.data[8:]
+
+	return v
+}
+
+// raw consumes n bytes of data. The returned slice aliases the decoder's
+// buffer.
+func (d *decoder) raw(n uint64) []byte {
+	if n > uint64(len(d.data)) {
+		d.fail(Error.New("truncated data"))
+
+		return nil
+	}
+
+	data := d.data[:n]
+	d.data = d.data[n:]
+
+	return data
+}
+
+// bytes consumes length prefixed data. The returned slice aliases the
+// decoder's buffer.
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if n > uint64(len(d.data)) {
+		d.fail(Error.New("truncated data"))
+
+		return nil
+	}
+
+	data := d.data[:n]
+	d.data = d.data[n:]
+
+	return data
+}
+
+// meta consumes annotations appended by encoder.meta.
+func (d *decoder) meta() map[string]string {
+	count := d.uvarint()
+	if count > uint64(len(d.data)) {
+		d.fail(Error.New("invalid annotation count %d", count))
+
+		return nil
+	}
+
+	m := make(map[string]string, count)
+
+	for j := uint64(0); j < count && d.err == nil; j++ {
+		k := string(d.bytes())
+		m[k] = string(d.bytes())
+	}
+
+	return m
+}
+
+// key consumes the key of a hasher.
+func (d *decoder) key() [2]uint64 {
+	return [2]uint64{d.uint64(), d.uint64()}
+}
+
+func (d *decoder) hash() *Hash {
+	key := d.key()
+
+	return NewHash(key[0], key[1])
+}
+
+// params consumes the parameter section calling fn with the tag and a
+// decoder for the value of each parameter.
+func (d *decoder) params(fn func(tag uint64, value *decoder)) {
+	section := &decoder{data: d.bytes()}
+
+	for len(section.data) > 0 && section.err == nil {
+		tag := section.uvarint()
+		value := &decoder{data: section.bytes()}
+
+		if section.err != nil {
+			break
+		}
+
+		fn(tag, value)
+
+		switch {
+		case value.err != nil:
+			section.fail(value.err)
+		case len(value.data) > 0:
+			section.fail(Error.New("trailing data in parameter %d", tag))
+		}
+	}
+
+	if section.err != nil {
+		d.fail(section.err)
+	}
+}
+
+// done checks that all the data was consumed and returns the first error
+// encountered.
+func (d *decoder) done() error {
+	if d.err == nil && len(d.data) > 0 {
+		d.fail(Error.New("trailing data"))
+	}
+
+	return d.err
+}
@@ -0,0 +1,151 @@
+package ibf
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncoding(t *testing.T) {
+	t.Run("ibf", func(t *testing.T) {
+		i0 := NewIBF(50, 1)
+		for j := 0; j < 1000; j++ {
+			i0.Insert([]byte(strconv.Itoa(j)))
+		}
+
+		data, err := i0.MarshalBinary()
+		require.NoError(t, err)
+		require.True(t, IsBinary(data))
+
+		i1 := &IBF{}
+		require.NoError(t, i1.UnmarshalBinary(data))
+		require.Equal(t, i0, i1)
+
+		v, err := Unmarshal(data)
+		require.NoError(t, err)
+		require.Equal(t, i0, v)
+	})
+
+	t.Run("strata", func(t *testing.T) {
+		s0 := NewStrata(1)
+		for j := 0; j < 1000; j++ {
+			s0.Insert([]byte(strconv.Itoa(j)))
+		}
+
+		data, err := s0.MarshalBinary()
+		require.NoError(t, err)
+
+		v, err := Unmarshal(data)
+		require.NoError(t, err)
+		require.Equal(t, s0, v)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		i0 := NewIBF(10, 1)
+		i0.Insert([]byte("a"))
+
+		data, err := json.Marshal(i0)
+		require.NoError(t, err)
+		require.False(t, IsBinary(data))
+
+		i1 := &IBF{}
+		require.NoError(t, json.Unmarshal(data, i1))
+		require.Equal(t, i0, i1)
+
+		// Invalid parameters are rejected like in the binary format.
+		var v map[string]interface{}
+		for _, tc := range []struct {
+			field string
+			value interface{}
+		}{
+			{"positioners", []interface{}{}},
+			{"placement", 9},
+			{"scheme", 9},
+			{"key_width", -1},
+			{"digest_bits", 32},
+		} {
+			require.NoError(t, json.Unmarshal(data, &v))
+			v[tc.field] = tc.value
+
+			invalid, err := json.Marshal(v)
+			require.NoError(t, err)
+			require.Error(t, json.Unmarshal(invalid, &IBF{}), tc.field)
+		}
+
+		s0 := NewStrataWithSize(4, 10, 1)
+
+		data, err = json.Marshal(s0)
+		require.NoError(t, err)
+
+		s1 := &Strata{}
+		require.NoError(t, json.Unmarshal(data, s1))
+		require.Equal(t, s0, s1)
+
+		for _, invalid := range []string{
+			`{}`,
+			`{"partitioner": {"key": [1, 2]}}`,
+			`{"partitioner": {"key": [1, 2]}, "strata": []}`,
+			`{"partitioner": {"key": [1, 2]}, "strata": [null]}`,
+			`{"strata": [{"size": 10}]}`,
+		} {
+			require.Error(t, json.Unmarshal([]byte(invalid), &Strata{}), invalid)
+		}
+	})
+
+	t.Run("meta", func(t *testing.T) {
+		i0 := NewIBF(10, 1)
+		i0.Insert([]byte("a"))
+		i0.Meta = map[string]string{"scan": "size,mode", "root": "/etc"}
+
+		data, err := i0.MarshalBinary()
+		require.NoError(t, err)
+
+		i1 := &IBF{}
+		require.NoError(t, i1.UnmarshalBinary(data))
+		require.Equal(t, i0, i1)
+
+		data, err = json.Marshal(i0)
+		require.NoError(t, err)
+
+		i2 := &IBF{}
+		require.NoError(t, json.Unmarshal(data, i2))
+		require.Equal(t, i0, i2)
+
+		// Annotations don't affect compatibility.
+		i3 := NewIBF(10, 1)
+		require.Equal(t, i3.Fingerprint(), i0.Fingerprint())
+		require.NoError(t, i3.Subtract(i0))
+
+		// Clones don't share them.
+		i4 := i0.Clone()
+		i4.Meta["root"] = "/usr"
+		require.Equal(t, "/etc", i0.Meta["root"])
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		i0 := NewIBF(10, 1)
+		i0.Insert([]byte("a"))
+
+		data, err := i0.MarshalBinary()
+		require.NoError(t, err)
+
+		s0 := &Strata{}
+		require.Error(t, s0.UnmarshalBinary(data))
+
+		version := append([]byte{}, data...)
+		version[len(magic)+1] = FormatVersion + 1
+		require.Error(t, (&IBF{}).UnmarshalBinary(version))
+
+		for n := 0; n < len(data); n++ {
+			require.Error(t, (&IBF{}).UnmarshalBinary(data[:n]), "truncated to %d", n)
+		}
+
+		trailing := append(append([]byte{}, data...), 0)
+		require.Error(t, (&IBF{}).UnmarshalBinary(trailing))
+
+		_, err = Unmarshal([]byte(`{"size": 10}`))
+		require.Error(t, err)
+	})
+}
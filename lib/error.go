@@ -8,4 +8,30 @@ var (
 
 	ErrNoPureCell = Error.New("no pure cell")
 	ErrEmptySet   = Error.New("empty set")
+	ErrNotFound   = Error.New("not found")
+
+	// ErrTooLarge is returned by a reconciliation when the difference
+	// could not be decoded with the largest size both sides have.
+	ErrTooLarge = Error.New("difference too large")
+
+	// ErrSuspiciousKey is returned when a cell passes the digest check but
+	// the key recovered from it does not hash to that cell. The key is
+	// garbage produced by a digest collision and is not reported.
+	ErrSuspiciousKey = Error.New("suspicious key")
+
+	// ErrIntegrity is returned when a patched file does not match the
+	// digest of the file the patch was made for.
+	ErrIntegrity = Error.New("integrity check failed")
+
+	// ErrIncompatible is the class of errors returned when combining sets
+	// that were not created with the same parameters.
+	ErrIncompatible = errs.Class("incompatible parameters")
+
+	// ErrPeer is the class of errors reported by the peer of a
+	// reconciliation.
+	ErrPeer = errs.Class("peer")
+
+	// ErrKeyWidth is the class of errors returned when inserting or
+	// removing a key of the wrong width from a set with fixed width keys.
+	ErrKeyWidth = errs.Class("invalid key width")
 )
@@ -1,9 +1,122 @@
 package ibf
 
 import (
+	"crypto/sha256"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
 	"github.com/dchest/siphash"
+	"github.com/zeebo/xxh3"
+)
+
+// Hasher computes keyed digests of values. An IBF uses hashers to pick the
+// cells for a key and to compute the digest that checks cells are pure.
+type Hasher interface {
+	// Name returns the name the hasher's algorithm is registered under.
+	Name() string
+
+	// GetKey returns the key the hasher was created with.
+	GetKey() [2]uint64
+
+	// Hash returns the 64-bit digest of the value.
+	Hash(value []byte) uint64
+
+	// Hash128 returns the 128-bit digest of the value.
+	Hash128(value []byte) (digest0, digest1 uint64)
+}
+
+// HasherFunc creates a hasher with the given key.
+type HasherFunc func(key0, key1 uint64) Hasher
+
+// DefaultHasher is the name of the hash algorithm used by default.
+const DefaultHasher = "siphash"
+
+// hashers holds the registered hash algorithms. It is guarded by hashersMu
+// since sets may be decoded concurrently with a registration.
+var (
+	hashersMu sync.RWMutex
+	hashers   = map[string]HasherFunc{
+		"siphash": func(key0, key1 uint64) Hasher {
+			return NewHash(key0, key1)
+		},
+		"siphash128": func(key0, key1 uint64) Hasher {
+			return &SipHash128{Key: [2]uint64{key0, key1}}
+		},
+		"xxhash64": func(key0, key1 uint64) Hasher {
+			return &XXHash64{Key: [2]uint64{key0, key1}}
+		},
+		"xxh3": func(key0, key1 uint64) Hasher {
+			return &XXH3{Key: [2]uint64{key0, key1}}
+		},
+	}
 )
 
+// RegisterHasher makes the hash algorithm available under the name. The name
+// is recorded in encoded IBFs, so the same algorithm must be registered under
+// the same name wherever they are decoded.
+func RegisterHasher(name string, fn HasherFunc) {
+	hashersMu.Lock()
+	defer hashersMu.Unlock()
+
+	hashers[name] = fn
+}
+
+// lookupHasher returns the registered hash algorithm.
+func lookupHasher(name string) (fn HasherFunc, ok bool) {
+	hashersMu.RLock()
+	defer hashersMu.RUnlock()
+
+	fn, ok = hashers[name]
+
+	return fn, ok
+}
+
+// NewHasher returns a new hasher using the named algorithm.
+func NewHasher(name string, key0, key1 uint64) (Hasher, error) {
+	fn, ok := lookupHasher(name)
+	if !ok {
+		return nil, Error.New("unknown hasher %q", name)
+	}
+
+	return fn(key0, key1), nil
+}
+
+// NewSeededHasher returns a new hasher using the named algorithm whose key is
+// created using the output from a random number generator initialized with the
+// seed.
+func NewSeededHasher(name string, seed int64) (Hasher, error) {
+	rng := rand.New(rand.NewSource(seed))
+
+	return NewHasher(name, uint64(rng.Int63()), uint64(rng.Int63()))
+}
+
+// HasherNames returns the names of the registered hash algorithms.
+func HasherNames() (names []string) {
+	hashersMu.RLock()
+	defer hashersMu.RUnlock()
+
+	for name := range hashers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// KeyDigestSize is the width of the keys stored by IBFs that hash their keys.
+const KeyDigestSize = sha256.Size
+
+// KeyDigest returns the SHA-256 digest of the element. IBFs that hash their
+// keys store this digest in place of the element.
+func KeyDigest(element []byte) []byte {
+	digest := sha256.Sum256(element)
+
+	return digest[:]
+}
+
 // Hash maintains the state for a siphash hasher.
 type Hash struct {
 	Key [2]uint64 `json:"key"`
@@ -16,7 +129,115 @@ func NewHash(key0, key1 uint64) *Hash {
 	}
 }
 
+// Name returns "siphash".
+func (h *Hash) Name() string {
+	return "siphash"
+}
+
+// GetKey returns the hasher's key.
+func (h *Hash) GetKey() [2]uint64 {
+	return h.Key
+}
+
 // Hash retuns the digest of the value.
 func (h *Hash) Hash(value []byte) (digest uint64) {
 	return siphash.Hash(h.Key[0], h.Key[1], value)
 }
+
+// Hash128 returns the 128-bit digest of the value.
+func (h *Hash) Hash128(value []byte) (digest0, digest1 uint64) {
+	return siphash.Hash128(h.Key[0], h.Key[1], value)
+}
+
+// SipHash128 is a hasher using the 128-bit output variant of siphash. Its
+// 64-bit digest folds the two halves together.
+type SipHash128 struct {
+	Key [2]uint64 `json:"key"`
+}
+
+// Name returns "siphash128".
+func (h *SipHash128) Name() string {
+	return "siphash128"
+}
+
+// GetKey returns the hasher's key.
+func (h *SipHash128) GetKey() [2]uint64 {
+	return h.Key
+}
+
+// Hash returns the digest of the value.
+func (h *SipHash128) Hash(value []byte) (digest uint64) {
+	digest0, digest1 := h.Hash128(value)
+
+	return digest0 ^ digest1
+}
+
+// Hash128 returns the 128-bit digest of the value.
+func (h *SipHash128) Hash128(value []byte) (digest0, digest1 uint64) {
+	return siphash.Hash128(h.Key[0], h.Key[1], value)
+}
+
+// XXHash64 is a hasher using xxHash64 seeded with each half of the key. It is
+// much faster than siphash, but it is not a keyed hash in the cryptographic
+// sense and should only be used on trusted data.
+type XXHash64 struct {
+	Key [2]uint64 `json:"key"`
+}
+
+// Name returns "xxhash64".
+func (h *XXHash64) Name() string {
+	return "xxhash64"
+}
+
+// GetKey returns the hasher's key.
+func (h *XXHash64) GetKey() [2]uint64 {
+	return h.Key
+}
+
+func (h *XXHash64) hash(seed uint64, value []byte) uint64 {
+	var d xxhash.Digest
+
+	d.ResetWithSeed(seed)
+	_, _ = d.Write(value)
+
+	return d.Sum64()
+}
+
+// Hash returns the digest of the value.
+func (h *XXHash64) Hash(value []byte) (digest uint64) {
+	return h.hash(h.Key[0], value)
+}
+
+// Hash128 returns the 128-bit digest of the value. It is computed as two
+// 64-bit digests seeded with each half of the key.
+func (h *XXHash64) Hash128(value []byte) (digest0, digest1 uint64) {
+	return h.hash(h.Key[0], value), h.hash(h.Key[1], value)
+}
+
+// XXH3 is a hasher using XXH3 seeded with the first half of the key. Like
+// XXHash64 it should only be used on trusted data.
+type XXH3 struct {
+	Key [2]uint64 `json:"key"`
+}
+
+// Name returns "xxh3".
+func (h *XXH3) Name() string {
+	return "xxh3"
+}
+
+// GetKey returns the hasher's key.
+func (h *XXH3) GetKey() [2]uint64 {
+	return h.Key
+}
+
+// Hash returns the digest of the value.
+func (h *XXH3) Hash(value []byte) (digest uint64) {
+	return xxh3.HashSeed(value, h.Key[0])
+}
+
+// Hash128 returns the 128-bit digest of the value.
+func (h *XXH3) Hash128(value []byte) (digest0, digest1 uint64) {
+	digest := xxh3.Hash128Seed(value, h.Key[0])
+
+	return digest.Lo, digest.Hi
+}
@@ -0,0 +1,121 @@
+package ibf
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHasher(t *testing.T) {
+	value := []byte("hello")
+
+	for _, name := range HasherNames() {
+		name := name
+
+		t.Run(name, func(t *testing.T) {
+			h0, err := NewHasher(name, 1, 2)
+			require.NoError(t, err)
+			require.Equal(t, name, h0.Name())
+			require.Equal(t, [2]uint64{1, 2}, h0.GetKey())
+
+			// Hashing is deterministic for the same key.
+			h1, err := NewHasher(name, 1, 2)
+			require.NoError(t, err)
+			require.Equal(t, h0.Hash(value), h1.Hash(value))
+
+			d0, d1 := h0.Hash128(value)
+			e0, e1 := h1.Hash128(value)
+			require.Equal(t, d0, e0)
+			require.Equal(t, d1, e1)
+			require.NotEqual(t, d0, d1)
+
+			// A different key produces different digests.
+			h2, err := NewHasher(name, 3, 4)
+			require.NoError(t, err)
+			require.NotEqual(t, h0.Hash(value), h2.Hash(value))
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := NewHasher("unknown", 1, 2)
+		require.Error(t, err)
+	})
+
+	t.Run("register", func(t *testing.T) {
+		RegisterHasher("test", func(key0, key1 uint64) Hasher {
+			return testHasher{&SipHash128{Key: [2]uint64{key0, key1}}}
+		})
+		defer func() {
+			hashersMu.Lock()
+			delete(hashers, "test")
+			hashersMu.Unlock()
+		}()
+
+		require.Contains(t, HasherNames(), "test")
+
+		h, err := NewHasher("test", 1, 2)
+		require.NoError(t, err)
+
+		i0 := NewIBFWithHash(10, []Hasher{h, h}, h)
+		i0.Insert([]byte("a"))
+
+		data, err := i0.MarshalBinary()
+		require.NoError(t, err)
+
+		i1 := &IBF{}
+		require.NoError(t, i1.UnmarshalBinary(data))
+		require.Equal(t, "test", i1.GetHash())
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		defer func() {
+			hashersMu.Lock()
+			delete(hashers, "concurrent")
+			hashersMu.Unlock()
+		}()
+
+		var wg sync.WaitGroup
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				RegisterHasher("concurrent", func(key0, key1 uint64) Hasher {
+					return NewHash(key0, key1)
+				})
+			}
+		}()
+
+		for j := 0; j < 100; j++ {
+			_, err := NewHasher(DefaultHasher, 1, 2)
+			require.NoError(t, err)
+		}
+
+		wg.Wait()
+	})
+}
+
+type testHasher struct {
+	*SipHash128
+}
+
+func (h testHasher) Name() string {
+	return "test"
+}
+
+func BenchmarkHasher(b *testing.B) {
+	value := []byte("0123456789abcdef0123456789abcdef")
+
+	for _, name := range HasherNames() {
+		h, err := NewHasher(name, 1, 2)
+		require.NoError(b, err)
+
+		b.Run(name, func(b *testing.B) {
+			for j := 0; j < b.N; j++ {
+				h.Hash(value)
+			}
+		})
+	}
+}
@@ -1,16 +1,47 @@
 package ibf
 
-import "math/rand"
+import (
+	"encoding/json"
+	"math/rand"
+
+	"github.com/dchest/siphash"
+)
 
 // IBF holds the state of an invertable bloom filter.
 type IBF struct {
-	Positioners []*Hash `json:"positioners"`
-	Hasher      *Hash   `json:"hasher"`
+	Positioners []Hasher
+	Hasher      Hasher
+	Placement   Placement
+	Scheme      Scheme
 
-	Size  uint64  `json:"size"`
-	Cells []*Cell `json:"cells"`
+	// Wide is true if the cells hold 128-bit digests.
+	Wide bool
 
-	Cardinality int64 `json:"cardinality"`
+	// KeyWidth is the width of every key in bytes or zero if keys can
+	// have any length.
+	KeyWidth int
+
+	// HashKeys is true if elements are replaced by their KeyDigest before
+	// they are stored.
+	HashKeys bool
+
+	// Multiset is true if the cells hold count weighted sums so that
+	// repeated keys accumulate.
+	Multiset bool
+
+	Size uint64
+
+	// NOTE: The cells used to be exported as Cells []*Cell. They are now
+	// kept in contiguous arrays; use GetCell and GetCells to read copies
+	// of them, SetCell to change one and IsPure to check them.
+	cells cells
+
+	Cardinality int64
+
+	// Meta holds free form annotations, such as how the elements were
+	// produced. It is kept by the encodings but isn't one of the
+	// parameters, so it doesn't affect compatibility.
+	Meta map[string]string
 }
 
 // NewIBF creates a new IBF of the given size. An IBF can accurately handle
@@ -19,124 +50,415 @@ type IBF struct {
 // and a hasher are created using the output from a random number generator
 // initialized with the seed.
 func NewIBF(size uint64, seed int64) *IBF {
+	return newIBF(size, seed, DefaultOptions)
+}
+
+// NewIBFWithOptions creates a new IBF of the given size configured by the
+// options. The positioners and hasher are created using the output from a
+// random number generator initialized with the seed. It returns an error if
+// the options are not valid for the size.
+func NewIBFWithOptions(size uint64, seed int64, opts Options) (*IBF, error) {
+	err := opts.validate(size)
+	if err != nil {
+		return nil, err
+	}
+
+	return newIBF(size, seed, opts), nil
+}
+
+func newIBF(size uint64, seed int64, opts Options) *IBF {
 	rng := rand.New(rand.NewSource(seed))
 
-	positioners := []*Hash{
-		NewHash(uint64(rng.Int63()), uint64(rng.Int63())),
-		NewHash(uint64(rng.Int63()), uint64(rng.Int63())),
-		NewHash(uint64(rng.Int63()), uint64(rng.Int63())),
+	fn, _ := lookupHasher(opts.hash())
+
+	positioners := make([]Hasher, opts.Hashes)
+	for j := range positioners {
+		positioners[j] = fn(uint64(rng.Int63()), uint64(rng.Int63()))
+	}
+	hasher := fn(uint64(rng.Int63()), uint64(rng.Int63()))
+
+	set := NewIBFWithHash(size, positioners, hasher)
+	set.Placement = opts.Placement
+	set.Scheme = opts.Scheme
+	set.Wide = opts.DigestBits == 128
+	set.KeyWidth = opts.KeyWidth
+	set.HashKeys = opts.HashKeys
+	set.Multiset = opts.Multiset
+
+	if set.HashKeys {
+		set.KeyWidth = KeyDigestSize
 	}
-	hasher := NewHash(uint64(rng.Int63()), uint64(rng.Int63()))
 
-	return NewIBFWithHash(size, positioners, hasher)
+	set.cells = newCells(size, set.storedKeyWidth())
+
+	return set
 }
 
 // NewIBFWithHash creates a new IBF with the provided positioners and hasher.
 // It will use the given hashers for positioning and computing the key hashes.
 // The positioners must all be initialized with different seeds to ensure they
-// do not produce the same positions for the same key.
-func NewIBFWithHash(size uint64, positioners []*Hash, hasher *Hash) *IBF {
-	cells := make([]*Cell, size)
-	for i := range cells {
-		cells[i] = NewCell()
-	}
-
+// do not produce the same positions for the same key, and they must all use
+// the same algorithm as the hasher so that the set can be encoded.
+func NewIBFWithHash(size uint64, positioners []Hasher, hasher Hasher) *IBF {
 	return &IBF{
 		Positioners: positioners,
 		Hasher:      hasher,
 
 		Size:  size,
-		Cells: cells,
+		cells: newCells(size, 0),
 
 		Cardinality: 0,
 	}
 }
 
-// getPositions returns the cells that the key would occupy. It always returns
-// len(positioners) many cells ensuring that no key is under represented.
-func (i *IBF) getPositions(key []byte, digest uint64) (cells []*Cell) {
-	cells = make([]*Cell, len(i.Positioners))
-	used := map[uint64]bool{}
+// maxStackHashes is the number of positions that can be located without
+// allocating.
+const maxStackHashes = 8
+
+// locate returns the digest of the key and the indices of the cells that the
+// key would occupy. It always returns len(positioners) many distinct indices
+// ensuring that no key is under represented. The indices are stored in buf
+// if it is large enough so that callers can avoid allocating.
+func (i *IBF) locate(key []byte, buf []uint64) (digest [2]uint64, indices []uint64) {
+	digest, hashes := i.hashKey(key, buf)
+
+	return digest, i.place(hashes)
+}
+
+// hashKey returns the digest of the key and the positioner hashes that place
+// converts into indices. The hashes do not depend on the size. They are stored
+// in buf if it is large enough.
+func (i *IBF) hashKey(key []byte, buf []uint64) (digest [2]uint64, hashes []uint64) {
+	hashes = buf[:0]
+	if cap(hashes) < len(i.Positioners) {
+		hashes = make([]uint64, 0, len(i.Positioners))
+	}
+	hashes = hashes[:len(i.Positioners)]
+
+	if i.Scheme == SchemeDouble {
+		// NOTE: The positions are derived from a single 128-bit hash
+		// by double hashing (h1 + j*h2). The second half is forced odd
+		// so that the steps never degenerate to zero. Each step is
+		// mixed before it is reduced to an index, otherwise two keys
+		// agreeing on h1 and h2 modulo the size would share every
+		// cell, which is likely in small IBFs and prevents decoding.
+		h1, h2 := i.Hasher.Hash128(key)
+
+		for j := range hashes {
+			hashes[j] = mix(h1 + uint64(j)*(h2|1))
+		}
+
+		return i.doubleDigest(h1, h2), hashes
+	}
 
 	for j, positioner := range i.Positioners {
-		index := positioner.Hash(key) % i.Size
+		hashes[j] = positioner.Hash(key)
+	}
+
+	return i.getDigest(key), hashes
+}
+
+// copyBytes returns a copy of the data.
+func copyBytes(data []byte) []byte {
+	c := make([]byte, len(data))
+	copy(c, data)
+
+	return c
+}
+
+// mix is the splitmix64 finalizer. It is a bijection that spreads every input
+// bit across the output.
+func mix(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+
+	return z ^ (z >> 31)
+}
+
+// place converts the positioner hashes into cell indices in place.
+func (i *IBF) place(hashes []uint64) (indices []uint64) {
+	indices = hashes
+
+	if i.Placement == PlacementPartitioned {
+		// NOTE: Each positioner has its own sub-table so the
+		// positions can't collide.
+		width := i.Size / uint64(len(hashes))
+
+		for j, hash := range hashes {
+			indices[j] = uint64(j)*width + hash%width
+		}
+
+		return indices
+	}
+
+	if i.Placement == PlacementModulo {
+		for j, hash := range hashes {
+			indices[j] = hash % i.Size
+		}
+
+		return indices
+	}
+
+	return probe(hashes, i.Size)
+}
+
+// probe converts the hashes into distinct indices less than size in place.
+func probe(hashes []uint64, size uint64) (indices []uint64) {
+	indices = hashes
+
+	for j, hash := range hashes {
+		index := hash % size
 
 		// NOTE: We need to keep looking if we have found a collision
 		// with an already used position.
-		for used[index] {
-			index = (index + 1) % i.Size
+		for contains(indices[:j], index) {
+			index = (index + 1) % size
 		}
 
-		used[index] = true
-		cells[j] = i.Cells[index]
+		indices[j] = index
 	}
 
-	return cells
+	return indices
+}
+
+// getDigest returns the digest of the key. The upper half is zero unless the
+// IBF uses 128-bit digests.
+func (i *IBF) getDigest(key []byte) (digest [2]uint64) {
+	if i.Scheme == SchemeDouble {
+		return i.doubleDigest(i.Hasher.Hash128(key))
+	}
+
+	if i.Wide {
+		digest[0], digest[1] = i.Hasher.Hash128(key)
+
+		return digest
+	}
+
+	return [2]uint64{i.Hasher.Hash(key), 0}
+}
+
+// doubleDigest returns the digest of a key from the 128-bit hash used by the
+// double hashing scheme.
+func (i *IBF) doubleDigest(h1, h2 uint64) [2]uint64 {
+	if i.Wide {
+		return [2]uint64{h1, h2}
+	}
+
+	return [2]uint64{h1 ^ h2, 0}
+}
+
+// verify returns true if the key hashes to the cell at the index.
+func (i *IBF) verify(key []byte, index uint64) bool {
+	var buf [maxStackHashes]uint64
+	_, indices := i.locate(key, buf[:])
+
+	return contains(indices, index)
+}
+
+// contains returns true if the index is one of the indices.
+func contains(indices []uint64, index uint64) bool {
+	for _, j := range indices {
+		if j == index {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsPure returns true if the cell at index holds exactly one key whose digest,
+// computed with the scheme and digest width of the IBF, matches the cell.
+func (i *IBF) IsPure(index uint64) bool {
+	if index >= i.Size {
+		return false
+	}
+
+	return i.isPure(index)
+}
+
+// isPure returns true if cell j contains exactly one key, either added (count
+// 1) or removed (count -1), and the digest is valid. In a multiset the key can
+// have any multiplicity.
+func (i *IBF) isPure(j uint64) bool {
+	_, ok := i.pure(j)
+
+	return ok
+}
+
+// pure returns the key in cell j if the cell is pure. The key may alias the
+// cell's storage.
+func (i *IBF) pure(j uint64) (key []byte, ok bool) {
+	count := i.cells.counts[j]
+
+	if i.Multiset {
+		key, ok = i.cells.unscale(j)
+		if !ok {
+			return nil, false
+		}
+
+		digest := reduceDigest(i.getDigest(key))
+		multiplicity := fromCount(count)
+
+		digest[0] = mulMod(digest[0], multiplicity)
+		digest[1] = mulMod(digest[1], multiplicity)
+
+		return key, i.cells.digests[j] == digest
+	}
+
+	if count == 1 || count == -1 {
+		key = i.cells.value(j)
+
+		return key, i.cells.digests[j] == i.getDigest(key)
+	}
+
+	return nil, false
 }
 
-// Insert adds the key to the set.
+// storedKeyWidth returns the width the cells are fixed to. Multisets store
+// limbs rather than keys, so their cells are never fixed.
+func (i *IBF) storedKeyWidth() int {
+	if i.Multiset {
+		return 0
+	}
+
+	return i.KeyWidth
+}
+
+// checkKey returns an ErrKeyWidth error if the set has fixed width keys and
+// the key has a different width.
+func (i *IBF) checkKey(key []byte) error {
+	if i.KeyWidth > 0 && len(key) != i.KeyWidth {
+		return ErrKeyWidth.New("%d != %d", len(key), i.KeyWidth)
+	}
+
+	return nil
+}
+
+// Insert adds the key to the set. If the set hashes its keys the KeyDigest of
+// the key is added instead. If the set has fixed width keys and the key has a
+// different width it returns an ErrKeyWidth error.
 //
 // NOTE: This does not know if the key already exists and will add it
 // unconditionally. If the key did already exist in the set, then that
-// effectively would remove it!
-func (i *IBF) Insert(key []byte) {
-	digest := i.Hasher.Hash(key)
-	cells := i.getPositions(key, digest)
+// effectively would remove it! Multisets count it twice instead.
+func (i *IBF) Insert(key []byte) error {
+	if i.HashKeys {
+		key = KeyDigest(key)
+	}
+
+	return i.InsertKey(key)
+}
+
+// InsertKey adds the key to the set as is, even if the set hashes its keys.
+// This is used to add keys recovered by decoding another set.
+func (i *IBF) InsertKey(key []byte) error {
+	err := i.checkKey(key)
+	if err != nil {
+		return err
+	}
 
-	for _, c := range cells {
-		c.Insert(key, digest)
+	var buf [maxStackHashes]uint64
+	digest, indices := i.locate(key, buf[:])
+
+	for _, index := range indices {
+		if i.Multiset {
+			i.cells.add(index, key, digest, 1)
+		} else {
+			i.cells.insert(index, key, digest)
+		}
 	}
 
 	i.Cardinality++
+
+	return nil
 }
 
-// Remove deletes the key from the set.
+// Remove deletes the key from the set. If the set hashes its keys the
+// KeyDigest of the key is deleted instead. If the set has fixed width keys and
+// the key has a different width it returns an ErrKeyWidth error.
 //
 // NOTE: This does not know if the key already exists and will add it
 // unconditionally. If the key did already exist in the set, then that
-// effectively would add it!
-func (i *IBF) Remove(key []byte) {
-	digest := i.Hasher.Hash(key)
-	cells := i.getPositions(key, digest)
+// effectively would add it! Multisets record a negative multiplicity instead.
+func (i *IBF) Remove(key []byte) error {
+	if i.HashKeys {
+		key = KeyDigest(key)
+	}
+
+	return i.RemoveKey(key)
+}
 
-	for _, c := range cells {
-		c.Remove(key, digest)
+// RemoveKey deletes the key from the set as is, even if the set hashes its
+// keys.
+func (i *IBF) RemoveKey(key []byte) error {
+	err := i.checkKey(key)
+	if err != nil {
+		return err
+	}
+
+	var buf [maxStackHashes]uint64
+	digest, indices := i.locate(key, buf[:])
+
+	for _, index := range indices {
+		if i.Multiset {
+			i.cells.add(index, key, digest, -1)
+		} else {
+			i.cells.remove(index, key, digest)
+		}
 	}
 
 	i.Cardinality--
+
+	return nil
 }
 
 // Invert flips the cardinality of the set and the cells. As if all elements
 // has instead been removed from the set instead of added.
 func (i *IBF) Invert() {
-	for _, cell := range i.Cells {
-		cell.Invert()
+	if i.Multiset {
+		i.cells.negate()
+	} else {
+		i.cells.invert()
 	}
 
 	i.Cardinality *= -1
 }
 
-// Pop finds a key in a pure cell, removes it from the set, and returns it. If
+// Pop finds a key in a pure cell, removes it from the set, and returns it. A
+// multiset only has one copy of the key removed. If
 // no pure cell can be found it returns ErrNoPureCell indicating that there are
-// more elements in the set, but they cannot be popped. If the set is empty it
-// returns ErrEmptySet.
+// more elements in the set, but they cannot be popped. If the only pure cells
+// hold keys that do not hash to them it returns ErrSuspiciousKey. If the set
+// is empty it returns ErrEmptySet.
 func (i *IBF) Pop() ([]byte, error) {
 	allEmpty := true
+	suspicious := false
 
 	// Look for a pure cell.
-	for _, cell := range i.Cells {
-		if cell.IsPure(i.Hasher) {
-			key := cell.GetKey()
-			i.Remove(key)
+	for j := uint64(0); j < i.Size; j++ {
+		if key, ok := i.pure(j); ok && i.cells.counts[j] > 0 {
+			key = copyBytes(key)
+
+			if !i.verify(key, j) {
+				suspicious = true
+
+				continue
+			}
+
+			i.RemoveKey(key)
 
 			return key, nil
 		}
 
-		if allEmpty && !cell.IsEmpty() {
+		if allEmpty && !i.cells.isEmpty(j) {
 			allEmpty = false
 		}
 	}
 
+	if suspicious {
+		return nil, ErrSuspiciousKey
+	}
+
 	// Are there non-empty cells?
 	if !allEmpty {
 		return nil, ErrNoPureCell
@@ -146,59 +468,415 @@ func (i *IBF) Pop() ([]byte, error) {
 	return nil, ErrEmptySet
 }
 
-// Union inserts all the elements from the provided set to this set.
+// Difference holds the keys recovered by Decode.
+type Difference struct {
+	// Left holds the keys with a positive count. After subtracting set B
+	// from set A these are the keys only in A.
+	Left [][]byte
+
+	// Right holds the keys with a negative count. After subtracting set B
+	// from set A these are the keys only in B.
+	Right [][]byte
+
+	// LeftCounts and RightCounts hold the signed multiplicity of each key
+	// in Left and Right. Outside of multisets they are always 1 and -1.
+	LeftCounts  []int64
+	RightCounts []int64
+
+	// Remaining holds the non-empty cells left over when decoding could
+	// not finish.
+	Remaining []*Cell
+}
+
+// Swap returns the difference seen from the other side: Left and Right are
+// exchanged and the signs of the counts flipped.
+func (d *Difference) Swap() *Difference {
+	return &Difference{
+		Left:        d.Right,
+		Right:       d.Left,
+		LeftCounts:  negateCounts(d.RightCounts),
+		RightCounts: negateCounts(d.LeftCounts),
+		Remaining:   d.Remaining,
+	}
+}
+
+// negateCounts returns the counts with their signs flipped.
+func negateCounts(counts []int64) []int64 {
+	if counts == nil {
+		return nil
+	}
+
+	negated := make([]int64, len(counts))
+	for j, count := range counts {
+		negated[j] = -count
+	}
+
+	return negated
+}
+
+// Decode lists the set by repeatedly peeling keys from pure cells. Keys from
+// cells with a count of 1 are removed and reported in Left while keys from
+// cells with a count of -1 are added back and reported in Right. Each peeled
+// key can only make the cells it occupies pure, so those are the only cells
+// checked again. This makes decoding O(size + Δ) instead of the O(size × Δ) of
+// calling Pop repeatedly.
+//
+// In a multiset a pure cell holds copies of a single key and the whole
+// multiplicity is peeled at once.
+//
+// Like Pop, the decoded keys are removed from the set. If some cells could not
+// be decoded it returns the partial difference, with the undecoded cells in
+// Remaining, and ErrNoPureCell. Keys recovered from cells they do not hash to
+// are left in place and, if they are still there at the end, ErrSuspiciousKey
+// is returned instead.
+func (i *IBF) Decode() (diff *Difference, err error) {
+	diff = &Difference{}
+
+	var buf [maxStackHashes]uint64
+
+	queue := []uint64{}
+	for j := uint64(0); j < i.Size; j++ {
+		if i.isPure(j) {
+			queue = append(queue, j)
+		}
+	}
+
+	for len(queue) > 0 {
+		j := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+
+		// NOTE: The cell may have been peeled or otherwise changed
+		// since it was queued.
+		key, ok := i.pure(j)
+		if !ok {
+			continue
+		}
+
+		key = copyBytes(key)
+		count := i.cells.counts[j]
+
+		digest, indices := i.locate(key, buf[:])
+
+		// NOTE: A key whose digest collides with the cell's can still
+		// be caught by checking that it hashes to the cell. Peeling it
+		// would corrupt the other cells.
+		if !contains(indices, j) {
+			continue
+		}
+
+		for _, index := range indices {
+			switch {
+			case i.Multiset:
+				i.cells.add(index, key, digest, -count)
+			case count > 0:
+				i.cells.remove(index, key, digest)
+			default:
+				i.cells.insert(index, key, digest)
+			}
+
+			if i.isPure(index) {
+				queue = append(queue, index)
+			}
+		}
+
+		if count > 0 {
+			diff.Left = append(diff.Left, key)
+			diff.LeftCounts = append(diff.LeftCounts, count)
+		} else {
+			diff.Right = append(diff.Right, key)
+			diff.RightCounts = append(diff.RightCounts, count)
+		}
+
+		i.Cardinality -= count
+	}
+
+	suspicious := false
+
+	for j := uint64(0); j < i.Size; j++ {
+		if !i.cells.isEmpty(j) {
+			diff.Remaining = append(diff.Remaining, i.cells.cell(j))
+
+			// NOTE: Any pure cell left over must have failed
+			// verification.
+			suspicious = suspicious || i.isPure(j)
+		}
+	}
+
+	if suspicious {
+		return diff, ErrSuspiciousKey
+	}
+
+	if len(diff.Remaining) > 0 {
+		return diff, ErrNoPureCell
+	}
+
+	return diff, nil
+}
+
+// Union inserts all the elements from the provided set to this set. If the
+// two sets were not created with the same parameters it returns an
+// ErrIncompatible error and leaves this set unchanged.
 //
-// NOTE: This assumes the two sets are disjoint and configured the same. If the
-// two sets are not disjoint this will actually perform a symmetric difference
-// and the cardinality will be incorrect! If the two sets are not configured
-// the same then the behavior is undefined and could potentially panic.
-func (i *IBF) Union(other *IBF) {
-	cells := other.GetCells()
+// NOTE: This assumes the two sets are disjoint. If the two sets are not
+// disjoint this will actually perform a symmetric difference and the
+// cardinality will be incorrect!
+func (i *IBF) Union(other *IBF) error {
+	err := i.Compatible(other)
+	if err != nil {
+		return err
+	}
 
-	for j := 0; j < len(i.Cells); j++ {
-		i.Cells[j].Union(cells[j])
+	if i.Multiset {
+		i.cells.combineMod(&other.cells, 1)
+	} else {
+		i.cells.combine(&other.cells, 1)
 	}
 
 	i.Cardinality += other.GetCardinality()
+
+	return nil
 }
 
-// Subtract removes all the elements from the provided set from this set.
+// Subtract removes all the elements from the provided set from this set. If
+// the two sets were not created with the same parameters it returns an
+// ErrIncompatible error and leaves this set unchanged.
 //
 // NOTE: This assumes the other set is a subset of this one. If that isn't true
 // then this will actually perform a symmetric difference and the cardinality
-// will be incorrect! If the two sets are not configured the same then the
-// behavior is undefined and could potentially panic.
-func (i *IBF) Subtract(other *IBF) {
-	cells := other.GetCells()
+// will be incorrect!
+func (i *IBF) Subtract(other *IBF) error {
+	err := i.Compatible(other)
+	if err != nil {
+		return err
+	}
 
-	for j := 0; j < len(i.Cells); j++ {
-		i.Cells[j].Subtract(cells[j])
+	if i.Multiset {
+		i.cells.combineMod(&other.cells, -1)
+	} else {
+		i.cells.combine(&other.cells, -1)
 	}
 
 	i.Cardinality -= other.GetCardinality()
+
+	return nil
+}
+
+// Compatible returns an ErrIncompatible error naming the first parameter that
+// differs between the two sets. Sets can only be combined if they have the
+// same size and hash parameters.
+func (i *IBF) Compatible(other *IBF) error {
+	if i.Size != other.Size {
+		return ErrIncompatible.New("size %d != %d", i.Size, other.Size)
+	}
+
+	return i.compatibleParams(other)
+}
+
+// compatibleParams returns an ErrIncompatible error naming the first parameter
+// other than the size that differs between the two sets.
+func (i *IBF) compatibleParams(other *IBF) error {
+	if i.Hasher.Name() != other.Hasher.Name() {
+		return ErrIncompatible.New("hash %s != %s", i.Hasher.Name(), other.Hasher.Name())
+	}
+
+	if len(i.Positioners) != len(other.Positioners) {
+		return ErrIncompatible.New("positioner count %d != %d", len(i.Positioners), len(other.Positioners))
+	}
+
+	for j := range i.Positioners {
+		if i.Positioners[j].GetKey() != other.Positioners[j].GetKey() {
+			return ErrIncompatible.New("positioner %d key %v != %v", j, i.Positioners[j].GetKey(), other.Positioners[j].GetKey())
+		}
+	}
+
+	if i.Hasher.GetKey() != other.Hasher.GetKey() {
+		return ErrIncompatible.New("hasher key %v != %v", i.Hasher.GetKey(), other.Hasher.GetKey())
+	}
+
+	if i.Placement != other.Placement {
+		return ErrIncompatible.New("placement %s != %s", i.Placement, other.Placement)
+	}
+
+	if i.Scheme != other.Scheme {
+		return ErrIncompatible.New("scheme %s != %s", i.Scheme, other.Scheme)
+	}
+
+	if i.Wide != other.Wide {
+		return ErrIncompatible.New("digest bits %d != %d", i.getDigestBits(), other.getDigestBits())
+	}
+
+	if i.KeyWidth != other.KeyWidth {
+		return ErrIncompatible.New("key width %d != %d", i.KeyWidth, other.KeyWidth)
+	}
+
+	if i.HashKeys != other.HashKeys {
+		return ErrIncompatible.New("hashed keys %t != %t", i.HashKeys, other.HashKeys)
+	}
+
+	if i.Multiset != other.Multiset {
+		return ErrIncompatible.New("multiset %t != %t", i.Multiset, other.Multiset)
+	}
+
+	return nil
+}
+
+// Fold returns a copy of this set with its size divided by the factor. Cell j
+// of the folded set is the combination of the cells whose index reduces to j,
+// so it is the set that would have been built at the smaller size from the
+// start. Only sets using the modulo or partitioned placement can be folded.
+// The factor must divide the size, or the size of each sub-table when
+// partitioned.
+func (i *IBF) Fold(factor uint64) (*IBF, error) {
+	if factor == 0 || i.Size%factor != 0 {
+		return nil, Error.New("fold factor %d does not divide the size %d", factor, i.Size)
+	}
+
+	size := i.Size / factor
+	width := i.Size / uint64(len(i.Positioners))
+
+	switch i.Placement {
+	case PlacementModulo:
+	case PlacementPartitioned:
+		if width%factor != 0 {
+			return nil, Error.New("fold factor %d does not divide the sub-table size %d", factor, width)
+		}
+	default:
+		return nil, Error.New("placement %s can't be folded", i.Placement)
+	}
+
+	if uint64(len(i.Positioners)) > size {
+		return nil, Error.New("folded size %d is smaller than the number of hashes %d", size, len(i.Positioners))
+	}
+
+	folded := &IBF{}
+	*folded = *i
+
+	folded.Size = size
+	folded.cells = newCells(size, i.storedKeyWidth())
+
+	for j := uint64(0); j < i.Size; j++ {
+		target := j % size
+		if i.Placement == PlacementPartitioned {
+			target = j/width*(width/factor) + j%width%(width/factor)
+		}
+
+		if i.Multiset {
+			folded.cells.addCellMod(target, &i.cells, j)
+		} else {
+			folded.cells.addCell(target, &i.cells, j)
+		}
+	}
+
+	return folded, nil
+}
+
+// Fingerprint returns a digest of the parameters that must match for two sets
+// to be combined: the size, the hash parameters and the format version. Two
+// sets with different fingerprints are not compatible.
+func (i *IBF) Fingerprint() uint64 {
+	e := &encoder{}
+	e.uvarint(FormatVersion)
+	i.encodeParams(e, false)
+
+	return siphash.Hash(0, 0, e.buf)
+}
+
+// GetFingerprint returns the fingerprint the set would have at the size, such
+// as the fingerprint of a fold of it or of another member of its bundle.
+func (i *IBF) GetFingerprint(size uint64) uint64 {
+	// NOTE: The fingerprint only covers the parameters, so a copy with
+	// the size changed has the fingerprint of the set at that size.
+	params := *i
+	params.Size = size
+
+	return params.Fingerprint()
 }
 
 // Clone returns a copy of this set.
 func (i *IBF) Clone() (clone *IBF) {
-	clone = NewIBFWithHash(i.Size, i.Positioners, i.Hasher)
+	clone = &IBF{}
+	*clone = *i
 
-	for j, c := range i.Cells {
-		clone.Cells[j] = c.Clone()
-	}
+	clone.cells = i.cells.clone()
 
-	clone.Cardinality = i.Cardinality
+	if i.Meta != nil {
+		clone.Meta = make(map[string]string, len(i.Meta))
+		for k, v := range i.Meta {
+			clone.Meta[k] = v
+		}
+	}
 
 	return clone
 }
 
+// GetHash returns the name of the hash algorithm used by the IBF.
+func (i *IBF) GetHash() string {
+	return i.Hasher.Name()
+}
+
+// getDigestBits returns the width of the cell digests.
+func (i *IBF) getDigestBits() int {
+	if i.Wide {
+		return 128
+	}
+
+	return 64
+}
+
 // GetSize returns the IBF's size.
 func (i *IBF) GetSize() uint64 {
 	return i.Size
 }
 
-// GetCells returns the IBF's cells.
+// GetCell returns a copy of the cell at the index.
+func (i *IBF) GetCell(index uint64) *Cell {
+	return i.cells.cell(index)
+}
+
+// GetCells returns a copy of the IBF's cells.
+//
+// NOTE: This used to return the IBF's own cells, so changing them changed the
+// IBF. The cells are now stored in contiguous arrays, so the returned cells
+// are snapshots: changes to them do not affect the IBF until they are written
+// back with SetCell, and later changes to the IBF are not reflected in them.
 func (i *IBF) GetCells() []*Cell {
-	return i.Cells
+	cells := make([]*Cell, i.Size)
+	for j := range cells {
+		cells[j] = i.cells.cell(uint64(j))
+	}
+
+	return cells
+}
+
+// SetCell replaces the cell at the index with a copy of the cell, such as one
+// returned by GetCell and then changed. The cell must fit the IBF: its key must
+// have the width of the IBF's keys and, in a multiset, hold reduced sums.
+func (i *IBF) SetCell(index uint64, cell *Cell) error {
+	if index >= i.Size {
+		return Error.New("index %d is outside of the IBF of size %d", index, i.Size)
+	}
+
+	if cell.Key == nil || len(cell.Key.Data) < 8 {
+		return Error.New("invalid cell key")
+	}
+
+	width := i.storedKeyWidth()
+	if width > 0 && len(cell.Key.Data) != 8+width {
+		return ErrKeyWidth.New("%d != %d", len(cell.Key.Data)-8, width)
+	}
+
+	if !i.Wide && cell.DigestHi != 0 {
+		return Error.New("cell has a 128-bit digest")
+	}
+
+	c := newCellsFrom([]*Cell{cell}, width)
+	if i.Multiset && !c.reduced() {
+		return Error.New("invalid multiset cell")
+	}
+
+	i.cells.set(index, &c, 0)
+
+	return nil
 }
 
 // GetCardinality returns the IBF's cardinality.
@@ -212,11 +890,433 @@ func (i *IBF) IsEmpty() bool {
 		return false
 	}
 
-	for _, cell := range i.Cells {
-		if !cell.IsEmpty() {
+	for j := uint64(0); j < i.Size; j++ {
+		if !i.cells.isEmpty(j) {
 			return false
 		}
 	}
 
 	return true
 }
+
+// minCellSize is the smallest number of bytes an encoded cell can occupy.
+// Cells with 128-bit digests take another 8 bytes.
+const minCellSize = 1 + 8 + 1 + 8
+
+// MarshalBinary encodes the IBF in the compact binary format. The encoding
+// starts with a header identifying the format version followed by the hash
+// parameters, the cardinality and the cells.
+func (i *IBF) MarshalBinary() (data []byte, err error) {
+	e := newEncoder(kindIBF)
+
+	i.encodeParams(e, true)
+	e.varint(i.Cardinality)
+
+	for j := uint64(0); j < i.Size; j++ {
+		e.varint(i.cells.counts[j])
+		e.uint64(i.cells.digests[j][0])
+		if i.Wide {
+			e.uint64(i.cells.digests[j][1])
+		}
+
+		// NOTE: Fixed width keys are stored inline without a length.
+		// Other sums are padded to the widest key ever inserted and
+		// are stored without the padding, the decoder pads them again.
+		if i.storedKeyWidth() > 0 {
+			e.raw(i.cells.keys.slot(j))
+		} else {
+			e.bytes(i.cells.keys.trimmed(j))
+		}
+	}
+
+	return e.buf, nil
+}
+
+// encodeParams appends the parameter section. The annotations are only
+// included if meta is true.
+func (i *IBF) encodeParams(e *encoder, meta bool) {
+	e.params(func(e *encoder) {
+		e.param(tagSize, func(e *encoder) {
+			e.uvarint(i.Size)
+		})
+		e.param(tagPositioners, func(e *encoder) {
+			e.uvarint(uint64(len(i.Positioners)))
+			for _, positioner := range i.Positioners {
+				e.hash(positioner)
+			}
+		})
+		e.param(tagHasher, func(e *encoder) {
+			e.hash(i.Hasher)
+		})
+
+		// NOTE: Optional parameters are left out when they have their
+		// default value.
+		if i.Hasher.Name() != DefaultHasher {
+			e.param(tagHash, func(e *encoder) {
+				e.bytes([]byte(i.Hasher.Name()))
+			})
+		}
+
+		if i.Placement != PlacementProbe {
+			e.param(tagPlacement, func(e *encoder) {
+				e.uvarint(uint64(i.Placement))
+			})
+		}
+
+		if i.Scheme != SchemeIndependent {
+			e.param(tagScheme, func(e *encoder) {
+				e.uvarint(uint64(i.Scheme))
+			})
+		}
+
+		if i.Wide {
+			e.param(tagDigestBits, func(e *encoder) {
+				e.uvarint(uint64(i.getDigestBits()))
+			})
+		}
+
+		if i.KeyWidth > 0 {
+			e.param(tagKeyWidth, func(e *encoder) {
+				e.uvarint(uint64(i.KeyWidth))
+			})
+		}
+
+		if i.HashKeys {
+			e.param(tagHashKeys, func(e *encoder) {
+				e.uvarint(1)
+			})
+		}
+
+		if i.Multiset {
+			e.param(tagMultiset, func(e *encoder) {
+				e.uvarint(1)
+			})
+		}
+
+		if meta && len(i.Meta) > 0 {
+			e.param(tagMeta, func(e *encoder) {
+				e.meta(i.Meta)
+			})
+		}
+	})
+}
+
+// UnmarshalBinary decodes an IBF encoded by MarshalBinary.
+func (i *IBF) UnmarshalBinary(data []byte) (err error) {
+	kind, data, err := decodeHeader(data)
+	if err != nil {
+		return err
+	}
+
+	if kind != kindIBF {
+		return Error.New("not an IBF")
+	}
+
+	d := &decoder{data: data}
+
+	var size uint64
+	var positionerKeys [][2]uint64
+	var hasherKey *[2]uint64
+	var name = DefaultHasher
+	var placement Placement
+	var scheme Scheme
+	var digestBits uint64 = 64
+	var keyWidth uint64
+	var hashKeys bool
+	var multiset bool
+	var meta map[string]string
+
+	d.params(func(tag uint64, v *decoder) {
+		switch tag {
+		case tagSize:
+			size = v.uvarint()
+		case tagPositioners:
+			count := v.uvarint()
+			if count > uint64(len(v.data))/16 {
+				v.fail(Error.New("truncated positioners"))
+
+				return
+			}
+
+			for j := uint64(0); j < count; j++ {
+				positionerKeys = append(positionerKeys, v.key())
+			}
+		case tagHasher:
+			key := v.key()
+			hasherKey = &key
+		case tagHash:
+			name = string(v.bytes())
+		case tagDigestBits:
+			digestBits = v.uvarint()
+		case tagKeyWidth:
+			keyWidth = v.uvarint()
+		case tagHashKeys:
+			hashKeys = v.uvarint() == 1
+		case tagMultiset:
+			multiset = v.uvarint() == 1
+		case tagMeta:
+			meta = v.meta()
+		case tagPlacement:
+			placement = Placement(v.uvarint())
+		case tagScheme:
+			scheme = Scheme(v.uvarint())
+		default:
+			v.fail(Error.New("unknown parameter %d", tag))
+		}
+	})
+
+	cardinality := d.varint()
+
+	if d.err != nil {
+		return d.err
+	}
+
+	// NOTE: Multisets store the keys of every width like variable width
+	// keys.
+	storedWidth := keyWidth
+	if multiset {
+		storedWidth = 0
+	}
+
+	switch {
+	case size == 0:
+		return Error.New("missing size")
+	case len(positionerKeys) == 0:
+		return Error.New("missing positioners")
+	case hasherKey == nil:
+		return Error.New("missing hasher")
+	case digestBits != 64 && digestBits != 128:
+		return Error.New("unsupported digest bits %d", digestBits)
+	case storedWidth > uint64(len(d.data)):
+		return Error.New("truncated cells")
+	case storedWidth > 0 && size > uint64(len(d.data))/(1+digestBits/8+storedWidth):
+		return Error.New("truncated cells")
+	case storedWidth == 0 && size > uint64(len(d.data))/(minCellSize+(digestBits-64)/8):
+		return Error.New("truncated cells")
+	}
+
+	wide := digestBits == 128
+
+	opts := Options{
+		Hashes:    len(positionerKeys),
+		Placement: placement,
+		Scheme:    scheme,
+		Hash:      name,
+		KeyWidth:  int(keyWidth),
+		HashKeys:  hashKeys,
+		Multiset:  multiset,
+	}
+
+	err = opts.validate(size)
+	if err != nil {
+		return err
+	}
+
+	fn, _ := lookupHasher(name)
+
+	positioners := make([]Hasher, len(positionerKeys))
+	for j, key := range positionerKeys {
+		positioners[j] = fn(key[0], key[1])
+	}
+	hasher := fn(hasherKey[0], hasherKey[1])
+
+	var c cells
+
+	if hashKeys && keyWidth != KeyDigestSize {
+		return Error.New("hashed keys require a key width of %d: %d", KeyDigestSize, keyWidth)
+	}
+
+	if storedWidth > 0 {
+		c = newCells(size, int(storedWidth))
+
+		for j := uint64(0); j < size; j++ {
+			c.counts[j] = d.varint()
+			c.digests[j][0] = d.uint64()
+			if wide {
+				c.digests[j][1] = d.uint64()
+			}
+			copy(c.keys.slot(j), d.raw(keyWidth))
+		}
+	} else {
+		cells := make([]*Cell, size)
+		for j := range cells {
+			cells[j] = decodeCell(d, wide)
+		}
+
+		if d.err == nil {
+			c = newCellsFrom(cells, 0)
+		}
+	}
+
+	err = d.done()
+	if err != nil {
+		return err
+	}
+
+	if multiset && !c.reduced() {
+		return Error.New("invalid multiset cells")
+	}
+
+	*i = IBF{
+		Positioners: positioners,
+		Hasher:      hasher,
+
+		Size:  size,
+		cells: c,
+
+		Cardinality: cardinality,
+
+		Placement: placement,
+		Scheme:    scheme,
+		Wide:      wide,
+		KeyWidth:  int(keyWidth),
+		HashKeys:  hashKeys,
+		Multiset:  multiset,
+
+		Meta: meta,
+	}
+
+	return nil
+}
+
+// jsonIBF is the JSON representation of an IBF. The hash name is left out
+// when it is the default so that sets written by earlier versions, which
+// always used siphash, decode unchanged.
+type jsonIBF struct {
+	Hash        string    `json:"hash,omitempty"`
+	Positioners []jsonKey `json:"positioners"`
+	Hasher      jsonKey   `json:"hasher"`
+	Placement   Placement `json:"placement,omitempty"`
+	Scheme      Scheme    `json:"scheme,omitempty"`
+	DigestBits  int       `json:"digest_bits,omitempty"`
+	KeyWidth    int       `json:"key_width,omitempty"`
+	HashKeys    bool      `json:"hash_keys,omitempty"`
+	Multiset    bool      `json:"multiset,omitempty"`
+
+	Size  uint64  `json:"size"`
+	Cells []*Cell `json:"cells"`
+
+	Cardinality int64 `json:"cardinality"`
+
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+type jsonKey struct {
+	Key [2]uint64 `json:"key"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (i *IBF) MarshalJSON() ([]byte, error) {
+	v := jsonIBF{
+		Positioners: make([]jsonKey, len(i.Positioners)),
+		Hasher:      jsonKey{i.Hasher.GetKey()},
+		Placement:   i.Placement,
+		Scheme:      i.Scheme,
+
+		Size:  i.Size,
+		Cells: i.GetCells(),
+
+		Cardinality: i.Cardinality,
+
+		Meta: i.Meta,
+	}
+
+	if i.Hasher.Name() != DefaultHasher {
+		v.Hash = i.Hasher.Name()
+	}
+
+	if i.Wide {
+		v.DigestBits = i.getDigestBits()
+	}
+
+	v.KeyWidth = i.KeyWidth
+	v.HashKeys = i.HashKeys
+	v.Multiset = i.Multiset
+
+	for j, positioner := range i.Positioners {
+		v.Positioners[j] = jsonKey{positioner.GetKey()}
+	}
+
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (i *IBF) UnmarshalJSON(data []byte) (err error) {
+	v := jsonIBF{}
+
+	err = json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+
+	if v.Hash == "" {
+		v.Hash = DefaultHasher
+	}
+
+	// NOTE: JSON files are checked like the binary ones so that a set
+	// loaded from either is usable (e.g. has at least one positioner).
+	err = Options{
+		Hashes:     len(v.Positioners),
+		Placement:  v.Placement,
+		Scheme:     v.Scheme,
+		Hash:       v.Hash,
+		DigestBits: v.DigestBits,
+		KeyWidth:   v.KeyWidth,
+		HashKeys:   v.HashKeys,
+		Multiset:   v.Multiset,
+	}.validate(v.Size)
+	if err != nil {
+		return err
+	}
+
+	fn, _ := lookupHasher(v.Hash)
+
+	if v.HashKeys && v.KeyWidth != KeyDigestSize {
+		return Error.New("hashed keys require a key width of %d: %d", KeyDigestSize, v.KeyWidth)
+	}
+
+	if uint64(len(v.Cells)) != v.Size {
+		return Error.New("size %d does not match cell count %d", v.Size, len(v.Cells))
+	}
+
+	for _, cell := range v.Cells {
+		if cell == nil || cell.Key == nil || len(cell.Key.Data) < 8 {
+			return Error.New("invalid cell")
+		}
+
+		if v.KeyWidth > 0 && !v.Multiset && len(cell.Key.Data) > 8+v.KeyWidth {
+			return Error.New("invalid cell")
+		}
+	}
+
+	positioners := make([]Hasher, len(v.Positioners))
+	for j, key := range v.Positioners {
+		positioners[j] = fn(key.Key[0], key.Key[1])
+	}
+
+	set := IBF{
+		Positioners: positioners,
+		Hasher:      fn(v.Hasher.Key[0], v.Hasher.Key[1]),
+		Placement:   v.Placement,
+		Scheme:      v.Scheme,
+		Wide:        v.DigestBits == 128,
+		KeyWidth:    v.KeyWidth,
+		HashKeys:    v.HashKeys,
+		Multiset:    v.Multiset,
+
+		Size:        v.Size,
+		Cardinality: v.Cardinality,
+
+		Meta: v.Meta,
+	}
+
+	set.cells = newCellsFrom(v.Cells, set.storedKeyWidth())
+
+	if set.Multiset && !set.cells.reduced() {
+		return Error.New("invalid multiset cells")
+	}
+
+	*i = set
+
+	return nil
+}
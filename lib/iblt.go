@@ -0,0 +1,514 @@
+package ibf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+)
+
+// IBLT holds the state of an invertible bloom lookup table. It is an IBF
+// whose cells also carry the XOR sum of the values stored with the keys, so
+// it can reconcile maps rather than sets.
+//
+// Each entry is placed in one cell per positioner, chosen by the key alone,
+// and in one more cell chosen by the key and the value together. The cell
+// digests cover both the key and the value. When two tables that map the same
+// key to different values are subtracted the key cells cancel out, but the
+// extra cells of the two entries differ, so both entries can still be
+// decoded.
+type IBLT struct {
+	// keys holds the parameters, the counts, the digests and the key sums.
+	keys *IBF
+
+	values sums
+}
+
+// NewIBLT creates a new IBLT of the given size with the default options.
+func NewIBLT(size uint64, seed int64) (*IBLT, error) {
+	return NewIBLTWithOptions(size, seed, DefaultOptions)
+}
+
+// NewIBLTWithOptions creates a new IBLT of the given size configured by the
+// options. Only the number of hashes, the hash algorithm, the digest width
+// and the key width can be changed. The size must be larger than the number
+// of hashes.
+func NewIBLTWithOptions(size uint64, seed int64, opts Options) (*IBLT, error) {
+	err := validateIBLT(size, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := NewIBFWithOptions(size, seed, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IBLT{
+		keys:   keys,
+		values: newSums(size, 0),
+	}, nil
+}
+
+// validateIBLT returns an error if the options can't be used for an IBLT of
+// the size.
+func validateIBLT(size uint64, opts Options) error {
+	switch {
+	case opts.Placement != PlacementProbe:
+		return Error.New("IBLT does not support placement %s", opts.Placement)
+	case opts.Scheme != SchemeIndependent:
+		return Error.New("IBLT does not support scheme %s", opts.Scheme)
+	case opts.HashKeys:
+		return Error.New("IBLT does not support hashed keys")
+	case opts.Multiset:
+		return Error.New("IBLT does not support multisets")
+	case uint64(opts.Hashes) >= size:
+		return Error.New("hashes must be less than the size (%d): %d", size, opts.Hashes)
+	}
+
+	return nil
+}
+
+// Pair is a key and the value stored with it.
+type Pair struct {
+	Key   []byte
+	Value []byte
+}
+
+// locate returns the digest of the entry and the indices of the cells it
+// occupies. The first len(positioners) indices only depend on the key.
+func (t *IBLT) locate(key, value []byte, buf []uint64) (digest [2]uint64, indices []uint64) {
+	k := len(t.keys.Positioners)
+
+	hashes := buf[:0]
+	if cap(hashes) < k+1 {
+		hashes = make([]uint64, 0, k+1)
+	}
+	hashes = hashes[:k+1]
+
+	for j, positioner := range t.keys.Positioners {
+		hashes[j] = positioner.Hash(key)
+	}
+
+	digest = t.getDigest(key, value)
+	hashes[k] = mix(digest[0] ^ digest[1])
+
+	return digest, probe(hashes, t.keys.Size)
+}
+
+// locateKey returns the indices of the cells chosen by the key alone.
+func (t *IBLT) locateKey(key []byte, buf []uint64) (indices []uint64) {
+	hashes := buf[:0]
+	for _, positioner := range t.keys.Positioners {
+		hashes = append(hashes, positioner.Hash(key))
+	}
+
+	// NOTE: Probing assigns the indices in order, so these are the same
+	// as the first indices returned by locate.
+	return probe(hashes, t.keys.Size)
+}
+
+// getDigest returns the digest of the entry. The upper half is zero unless
+// the IBLT uses 128-bit digests.
+func (t *IBLT) getDigest(key, value []byte) (digest [2]uint64) {
+	// NOTE: The key is length prefixed so that the boundary between key
+	// and value is part of the digest.
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(scratch[:], uint64(len(key)))
+
+	pair := make([]byte, 0, n+len(key)+len(value))
+	pair = append(pair, scratch[:n]...)
+	pair = append(pair, key...)
+	pair = append(pair, value...)
+
+	digest[0], digest[1] = t.keys.Hasher.Hash128(pair)
+
+	if !t.keys.Wide {
+		digest[1] = 0
+	}
+
+	return digest
+}
+
+// isPure returns true if cell j contains exactly one entry, either added
+// (count 1) or removed (count -1), and the digest is valid.
+func (t *IBLT) isPure(j uint64) bool {
+	if count := t.keys.cells.counts[j]; count == 1 || count == -1 {
+		return t.keys.cells.digests[j] == t.getDigest(t.keys.cells.value(j), t.values.value(j))
+	}
+
+	return false
+}
+
+// update adds (sign 1) or removes (sign -1) the entry.
+func (t *IBLT) update(key, value []byte, sign int64) error {
+	err := t.keys.checkKey(key)
+	if err != nil {
+		return err
+	}
+
+	var buf [maxStackHashes]uint64
+	digest, indices := t.locate(key, value, buf[:])
+
+	for _, index := range indices {
+		if sign > 0 {
+			t.keys.cells.insert(index, key, digest)
+		} else {
+			t.keys.cells.remove(index, key, digest)
+		}
+
+		t.values.xor(index, value)
+	}
+
+	t.keys.Cardinality += sign
+
+	return nil
+}
+
+// Put adds the key with the value to the table. If the table has fixed width
+// keys and the key has a different width it returns an ErrKeyWidth error.
+//
+// NOTE: Like IBF.Insert this does not know if the key already exists. To
+// change the value of a key first Delete it with its current value.
+func (t *IBLT) Put(key, value []byte) error {
+	return t.update(key, value, 1)
+}
+
+// Delete removes the key with the value from the table. The value must be the
+// one the key was put with.
+func (t *IBLT) Delete(key, value []byte) error {
+	return t.update(key, value, -1)
+}
+
+// Get looks up the value of the key. It returns ErrNotFound if the key is
+// definitely not in the table. Lookups are probabilistic: if every cell of the
+// key holds other entries too it returns ErrNoPureCell.
+func (t *IBLT) Get(key []byte) (value []byte, err error) {
+	var buf [maxStackHashes]uint64
+
+	for _, index := range t.locateKey(key, buf[:]) {
+		if t.keys.cells.isEmpty(index) && t.values.isZero(index) {
+			return nil, ErrNotFound
+		}
+
+		if t.keys.cells.counts[index] == 1 && t.isPure(index) {
+			if !bytes.Equal(t.keys.cells.value(index), key) {
+				// NOTE: The key would be in this cell, but the
+				// only entry here is another one.
+				return nil, ErrNotFound
+			}
+
+			return copyBytes(t.values.value(index)), nil
+		}
+	}
+
+	return nil, ErrNoPureCell
+}
+
+// PairDifference holds the entries recovered by Decode.
+type PairDifference struct {
+	// Left holds the entries with a positive count. After subtracting
+	// table B from table A these are the entries only in A.
+	Left []Pair
+
+	// Right holds the entries with a negative count. After subtracting
+	// table B from table A these are the entries only in B.
+	Right []Pair
+
+	// Remaining holds the non-empty cells left over when decoding could
+	// not finish. The cells do not include the value sums.
+	Remaining []*Cell
+}
+
+// Change is a key whose value differs between two tables.
+type Change struct {
+	Key   []byte
+	Left  []byte
+	Right []byte
+}
+
+// Compare classifies the entries of the difference. Keys found on both sides
+// are reported as changed and the rest as only left or only right.
+func (d *PairDifference) Compare() (left, right []Pair, changed []Change) {
+	rights := map[string][]byte{}
+	for _, pair := range d.Right {
+		rights[string(pair.Key)] = pair.Value
+	}
+
+	lefts := map[string]bool{}
+
+	for _, pair := range d.Left {
+		value, ok := rights[string(pair.Key)]
+		if !ok {
+			left = append(left, pair)
+
+			continue
+		}
+
+		lefts[string(pair.Key)] = true
+		changed = append(changed, Change{
+			Key:   pair.Key,
+			Left:  pair.Value,
+			Right: value,
+		})
+	}
+
+	for _, pair := range d.Right {
+		if !lefts[string(pair.Key)] {
+			right = append(right, pair)
+		}
+	}
+
+	return left, right, changed
+}
+
+// Decode lists the table by peeling entries from pure cells in the same way
+// as IBF.Decode. The decoded entries are removed from the table. If some cells
+// could not be decoded it returns the partial difference and ErrNoPureCell,
+// or ErrSuspiciousKey if a pure cell held an entry that does not hash to it.
+func (t *IBLT) Decode() (diff *PairDifference, err error) {
+	diff = &PairDifference{}
+
+	var buf [maxStackHashes]uint64
+
+	queue := []uint64{}
+	for j := uint64(0); j < t.keys.Size; j++ {
+		if t.isPure(j) {
+			queue = append(queue, j)
+		}
+	}
+
+	for len(queue) > 0 {
+		j := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+
+		// NOTE: The cell may have been peeled or otherwise changed
+		// since it was queued.
+		if !t.isPure(j) {
+			continue
+		}
+
+		pair := Pair{
+			Key:   copyBytes(t.keys.cells.value(j)),
+			Value: copyBytes(t.values.value(j)),
+		}
+		count := t.keys.cells.counts[j]
+
+		digest, indices := t.locate(pair.Key, pair.Value, buf[:])
+		if !contains(indices, j) {
+			continue
+		}
+
+		for _, index := range indices {
+			if count > 0 {
+				t.keys.cells.remove(index, pair.Key, digest)
+			} else {
+				t.keys.cells.insert(index, pair.Key, digest)
+			}
+
+			t.values.xor(index, pair.Value)
+
+			if t.isPure(index) {
+				queue = append(queue, index)
+			}
+		}
+
+		if count > 0 {
+			diff.Left = append(diff.Left, pair)
+			t.keys.Cardinality--
+		} else {
+			diff.Right = append(diff.Right, pair)
+			t.keys.Cardinality++
+		}
+	}
+
+	suspicious := false
+
+	for j := uint64(0); j < t.keys.Size; j++ {
+		if !t.keys.cells.isEmpty(j) || !t.values.isZero(j) {
+			diff.Remaining = append(diff.Remaining, t.keys.cells.cell(j))
+
+			// NOTE: Any pure cell left over must have failed
+			// verification.
+			suspicious = suspicious || t.isPure(j)
+		}
+	}
+
+	if suspicious {
+		return diff, ErrSuspiciousKey
+	}
+
+	if len(diff.Remaining) > 0 {
+		return diff, ErrNoPureCell
+	}
+
+	return diff, nil
+}
+
+// Union adds all the entries from the other table to this one. If the two
+// tables were not created with the same parameters it returns an
+// ErrIncompatible error and leaves this table unchanged.
+func (t *IBLT) Union(other *IBLT) error {
+	err := t.keys.Union(other.keys)
+	if err != nil {
+		return err
+	}
+
+	t.values.combine(&other.values)
+
+	return nil
+}
+
+// Subtract removes all the entries in the other table from this one. If the
+// two tables were not created with the same parameters it returns an
+// ErrIncompatible error and leaves this table unchanged.
+func (t *IBLT) Subtract(other *IBLT) error {
+	err := t.keys.Subtract(other.keys)
+	if err != nil {
+		return err
+	}
+
+	t.values.combine(&other.values)
+
+	return nil
+}
+
+// Compatible returns an ErrIncompatible error naming the first parameter that
+// differs between the two tables.
+func (t *IBLT) Compatible(other *IBLT) error {
+	return t.keys.Compatible(other.keys)
+}
+
+// Clone returns a copy of this table.
+func (t *IBLT) Clone() *IBLT {
+	return &IBLT{
+		keys:   t.keys.Clone(),
+		values: t.values.clone(),
+	}
+}
+
+// GetSize returns the IBLT's size.
+func (t *IBLT) GetSize() uint64 {
+	return t.keys.Size
+}
+
+// GetCardinality returns the IBLT's cardinality.
+func (t *IBLT) GetCardinality() int64 {
+	return t.keys.Cardinality
+}
+
+// IsEmpty returns true if all the cells are empty and the cardinality is zero.
+func (t *IBLT) IsEmpty() bool {
+	if !t.keys.IsEmpty() {
+		return false
+	}
+
+	for j := uint64(0); j < t.keys.Size; j++ {
+		if !t.values.isZero(j) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MarshalBinary encodes the IBLT in the compact binary format. The encoding
+// holds the encoding of the key cells as an IBF followed by the value sums.
+func (t *IBLT) MarshalBinary() (data []byte, err error) {
+	e := newEncoder(kindIBLT)
+
+	keys, err := t.keys.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	e.bytes(keys)
+
+	for j := uint64(0); j < t.keys.Size; j++ {
+		e.bytes(t.values.slot(j))
+	}
+
+	return e.buf, nil
+}
+
+// UnmarshalBinary decodes an IBLT encoded by MarshalBinary.
+func (t *IBLT) UnmarshalBinary(data []byte) (err error) {
+	kind, data, err := decodeHeader(data)
+	if err != nil {
+		return err
+	}
+
+	if kind != kindIBLT {
+		return Error.New("not an IBLT")
+	}
+
+	d := &decoder{data: data}
+
+	keys := &IBF{}
+
+	err = keys.UnmarshalBinary(d.bytes())
+	if d.err != nil {
+		return d.err
+	}
+	if err != nil {
+		return err
+	}
+
+	err = validateIBLT(keys.Size, Options{
+		Hashes:    len(keys.Positioners),
+		Placement: keys.Placement,
+		Scheme:    keys.Scheme,
+		HashKeys:  keys.HashKeys,
+		Multiset:  keys.Multiset,
+	})
+	if err != nil {
+		return err
+	}
+
+	// NOTE: Every value sum holds at least its length.
+	if keys.Size > uint64(len(d.data))/9 {
+		return Error.New("truncated values")
+	}
+
+	blocks := make([]*block, keys.Size)
+	for j := range blocks {
+		data := d.bytes()
+		if d.err == nil && len(data) < 8 {
+			d.fail(Error.New("truncated value"))
+		}
+
+		if d.err != nil {
+			return d.err
+		}
+
+		blocks[j] = &block{Data: data}
+	}
+
+	err = d.done()
+	if err != nil {
+		return err
+	}
+
+	*t = IBLT{
+		keys:   keys,
+		values: newSumsFrom(blocks, 0),
+	}
+
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler. The table is presented as the IBF
+// holding its keys and the value sum of each cell.
+func (t *IBLT) MarshalJSON() ([]byte, error) {
+	values := make([]*block, t.keys.Size)
+	for j := range values {
+		values[j] = t.values.block(uint64(j), 0)
+	}
+
+	return json.Marshal(struct {
+		Keys   *IBF     `json:"keys"`
+		Values []*block `json:"values"`
+	}{
+		Keys:   t.keys,
+		Values: values,
+	})
+}
@@ -0,0 +1,198 @@
+package ibf
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIBLT(t *testing.T) {
+	t.Run("get", func(t *testing.T) {
+		t0, err := NewIBLT(50, 1)
+		require.NoError(t, err)
+
+		require.NoError(t, t0.Put([]byte("a"), []byte("1")))
+		require.NoError(t, t0.Put([]byte("b"), []byte("2")))
+		require.Equal(t, int64(2), t0.GetCardinality())
+
+		value, err := t0.Get([]byte("a"))
+		require.NoError(t, err)
+		require.Equal(t, "1", string(value))
+
+		value, err = t0.Get([]byte("b"))
+		require.NoError(t, err)
+		require.Equal(t, "2", string(value))
+
+		_, err = t0.Get([]byte("c"))
+		require.Equal(t, ErrNotFound, err)
+
+		require.NoError(t, t0.Delete([]byte("a"), []byte("1")))
+
+		_, err = t0.Get([]byte("a"))
+		require.Equal(t, ErrNotFound, err)
+
+		require.NoError(t, t0.Delete([]byte("b"), []byte("2")))
+		require.True(t, t0.IsEmpty())
+	})
+
+	t.Run("get crowded", func(t *testing.T) {
+		t0, err := NewIBLT(4, 1)
+		require.NoError(t, err)
+
+		for i := 0; i < 100; i++ {
+			require.NoError(t, t0.Put([]byte(strconv.Itoa(i)), []byte("v")))
+		}
+
+		_, err = t0.Get([]byte("0"))
+		require.Equal(t, ErrNoPureCell, err)
+	})
+
+	t.Run("decode", func(t *testing.T) {
+		t0, err := NewIBLT(80, 1)
+		require.NoError(t, err)
+
+		expected := map[string]string{}
+		for i := 0; i < 20; i++ {
+			key, value := strconv.Itoa(i), strconv.Itoa(i*i)
+			expected[key] = value
+
+			require.NoError(t, t0.Put([]byte(key), []byte(value)))
+		}
+
+		diff, err := t0.Decode()
+		require.NoError(t, err)
+		require.Empty(t, diff.Right)
+		require.Empty(t, diff.Remaining)
+
+		actual := map[string]string{}
+		for _, pair := range diff.Left {
+			actual[string(pair.Key)] = string(pair.Value)
+		}
+
+		require.Equal(t, expected, actual)
+		require.True(t, t0.IsEmpty())
+	})
+
+	t.Run("compare", func(t *testing.T) {
+		t0, err := NewIBLT(80, 1)
+		require.NoError(t, err)
+
+		t1, err := NewIBLT(80, 1)
+		require.NoError(t, err)
+
+		for i := 0; i < 100; i++ {
+			key := []byte(strconv.Itoa(i))
+			require.NoError(t, t0.Put(key, []byte("v")))
+			require.NoError(t, t1.Put(key, []byte("v")))
+		}
+
+		require.NoError(t, t0.Put([]byte("left"), []byte("l")))
+		require.NoError(t, t1.Put([]byte("right"), []byte("r")))
+		require.NoError(t, t0.Put([]byte("changed"), []byte("old")))
+		require.NoError(t, t1.Put([]byte("changed"), []byte("new")))
+
+		require.NoError(t, t0.Subtract(t1))
+
+		diff, err := t0.Decode()
+		require.NoError(t, err)
+
+		left, right, changed := diff.Compare()
+		require.Equal(t, []Pair{{Key: []byte("left"), Value: []byte("l")}}, left)
+		require.Equal(t, []Pair{{Key: []byte("right"), Value: []byte("r")}}, right)
+		require.Equal(t, []Change{{
+			Key:   []byte("changed"),
+			Left:  []byte("old"),
+			Right: []byte("new"),
+		}}, changed)
+	})
+
+	t.Run("decode incomplete", func(t *testing.T) {
+		t0, err := NewIBLT(4, 1)
+		require.NoError(t, err)
+
+		for i := 0; i < 100; i++ {
+			require.NoError(t, t0.Put([]byte(strconv.Itoa(i)), []byte("v")))
+		}
+
+		diff, err := t0.Decode()
+		require.Equal(t, ErrNoPureCell, err)
+		require.NotEmpty(t, diff.Remaining)
+	})
+
+	t.Run("options", func(t *testing.T) {
+		for _, opts := range []Options{
+			{Hashes: 3, Placement: PlacementPartitioned},
+			{Hashes: 3, Scheme: SchemeDouble},
+			{Hashes: 3, HashKeys: true},
+			{Hashes: 10},
+		} {
+			_, err := NewIBLTWithOptions(10, 1, opts)
+			require.Error(t, err)
+		}
+
+		opts := DefaultOptions
+		opts.Hash = "xxh3"
+		opts.DigestBits = 128
+		opts.KeyWidth = 4
+
+		t0, err := NewIBLTWithOptions(40, 1, opts)
+		require.NoError(t, err)
+
+		require.NoError(t, t0.Put([]byte("abcd"), []byte("long value")))
+		require.True(t, ErrKeyWidth.Has(t0.Put([]byte("abc"), nil)))
+
+		value, err := t0.Get([]byte("abcd"))
+		require.NoError(t, err)
+		require.Equal(t, "long value", string(value))
+
+		t1, err := NewIBLT(40, 1)
+		require.NoError(t, err)
+		require.True(t, ErrIncompatible.Has(t0.Union(t1)))
+	})
+
+	t.Run("encoding", func(t *testing.T) {
+		t0, err := NewIBLT(30, 1)
+		require.NoError(t, err)
+
+		keys := []string{}
+		for i := 0; i < 10; i++ {
+			key := strconv.Itoa(i)
+			keys = append(keys, key)
+
+			require.NoError(t, t0.Put([]byte(key), []byte("value "+key)))
+		}
+
+		data, err := t0.MarshalBinary()
+		require.NoError(t, err)
+
+		u, err := Unmarshal(data)
+		require.NoError(t, err)
+
+		t1, ok := u.(*IBLT)
+		require.True(t, ok)
+		require.Equal(t, t0, t1)
+
+		_, err = json.Marshal(t1)
+		require.NoError(t, err)
+
+		for n := range data {
+			require.Error(t, t1.UnmarshalBinary(data[:n]))
+		}
+
+		diff, err := t0.Decode()
+		require.NoError(t, err)
+
+		decoded := []string{}
+		for _, pair := range diff.Left {
+			decoded = append(decoded, string(pair.Key))
+			require.Equal(t, "value "+string(pair.Key), string(pair.Value))
+		}
+
+		sort.Strings(keys)
+		sort.Strings(decoded)
+		require.Equal(t, keys, decoded)
+	})
+}
@@ -0,0 +1,278 @@
+package ibf
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// In multiset mode the cells hold count weighted sums instead of XOR sums, so
+// inserting a key twice adds it twice rather than cancelling it out. The sums
+// are computed in the field of integers modulo the prime p = 2^61 - 1. A key
+// is split into limbs: its length followed by its bytes in chunks of
+// limbBytes, each chunk read as a big endian integer padded with zeros on the
+// right. Every limb is smaller than p. A key sum is stored like a variable
+// width sum, one 8 byte word per limb, so limb 0 sits where the length of a
+// block would be.
+//
+// A cell holding c copies of a single key holds c times each limb and c times
+// the digest of the key. Multiplying by the inverse of c recovers the key,
+// which is then checked against the digest.
+
+// modulus is the Mersenne prime 2^61 - 1.
+const modulus = 1<<61 - 1
+
+// limbBytes is the number of key bytes in each limb. 7 bytes always fit below
+// the modulus.
+const limbBytes = 7
+
+// limbCount returns the number of limbs of a key with n bytes.
+func limbCount(n int) int {
+	return 1 + (n+limbBytes-1)/limbBytes
+}
+
+// reduce returns x modulo the modulus.
+func reduce(x uint64) uint64 {
+	x = (x & modulus) + (x >> 61)
+	if x >= modulus {
+		x -= modulus
+	}
+
+	return x
+}
+
+// addMod returns a + b modulo the modulus. Both must be reduced.
+func addMod(a, b uint64) uint64 {
+	// NOTE: a + b < 2^62 so it can't overflow.
+	return reduce(a + b)
+}
+
+// negMod returns -a modulo the modulus. a must be reduced.
+func negMod(a uint64) uint64 {
+	if a == 0 {
+		return 0
+	}
+
+	return modulus - a
+}
+
+// mulMod returns a * b modulo the modulus. Both must be reduced.
+func mulMod(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+
+	// NOTE: The product is hi*2^64 + lo and 2^61 is 1 modulo the
+	// modulus, so the bits above 61 can be folded back down.
+	return reduce((lo & modulus) + (lo>>61 | hi<<3))
+}
+
+// invMod returns the multiplicative inverse of a modulo the modulus. a must be
+// reduced and non-zero.
+func invMod(a uint64) uint64 {
+	// NOTE: By Fermat's little theorem a^(p-2) is the inverse of a.
+	result := uint64(1)
+	for e := uint64(modulus - 2); e > 0; e >>= 1 {
+		if e&1 == 1 {
+			result = mulMod(result, a)
+		}
+		a = mulMod(a, a)
+	}
+
+	return result
+}
+
+// fromCount returns the count as a field element.
+func fromCount(count int64) uint64 {
+	if count < 0 {
+		return negMod(reduce(uint64(-count)))
+	}
+
+	return reduce(uint64(count))
+}
+
+// limb returns limb n of the key without allocating.
+func limb(key []byte, n int) uint64 {
+	if n == 0 {
+		return uint64(len(key))
+	}
+
+	var chunk [8]byte
+	copy(chunk[1:], key[(n-1)*limbBytes:])
+
+	return binary.BigEndian.Uint64(chunk[:])
+}
+
+// add adds the key, weighted by the multiplicity, to sum j.
+func (s *sums) add(j uint64, key []byte, multiplicity uint64) {
+	limbs := limbCount(len(key))
+
+	s.reserve(8 * limbs)
+
+	slot := s.slot(j)
+	for n := 0; n < limbs; n++ {
+		word := slot[8*n:]
+		sum := addMod(binary.BigEndian.Uint64(word), mulMod(limb(key, n), multiplicity))
+		binary.BigEndian.PutUint64(word, sum)
+	}
+}
+
+// combineMod adds the other sums, weighted by the sign, to these.
+func (s *sums) combineMod(other *sums, sign int64) {
+	s.reserve(other.width)
+
+	for j := uint64(0); j < uint64(s.len()); j++ {
+		slot, from := s.slot(j), other.slot(j)
+
+		for n := 0; n+8 <= len(from); n += 8 {
+			v := binary.BigEndian.Uint64(from[n:])
+			if sign < 0 {
+				v = negMod(v)
+			}
+
+			binary.BigEndian.PutUint64(slot[n:], addMod(binary.BigEndian.Uint64(slot[n:]), v))
+		}
+	}
+}
+
+// negate negates every sum.
+func (s *sums) negate() {
+	for n := 0; n+8 <= len(s.data); n += 8 {
+		binary.BigEndian.PutUint64(s.data[n:], negMod(binary.BigEndian.Uint64(s.data[n:])))
+	}
+}
+
+// reduced returns true if every limb of the sums is smaller than the modulus.
+func (s *sums) reduced() bool {
+	for n := 0; n+8 <= len(s.data); n += 8 {
+		if binary.BigEndian.Uint64(s.data[n:]) >= modulus {
+			return false
+		}
+	}
+
+	return true
+}
+
+// unscale returns the key in sum j assuming the sum holds the key weighted by
+// the multiplicity. It returns false if the sum can't hold a single key.
+func (s *sums) unscale(j uint64, multiplicity uint64) (key []byte, ok bool) {
+	slot := s.slot(j)
+	inverse := invMod(multiplicity)
+
+	size := mulMod(binary.BigEndian.Uint64(slot), inverse)
+	if size > uint64(len(slot)) {
+		return nil, false
+	}
+
+	limbs := limbCount(int(size))
+	if 8*limbs > len(slot) {
+		return nil, false
+	}
+
+	key = make([]byte, (limbs-1)*limbBytes)
+
+	for n := 1; n < limbs; n++ {
+		v := mulMod(binary.BigEndian.Uint64(slot[8*n:]), inverse)
+		if v>>(8*limbBytes) != 0 {
+			return nil, false
+		}
+
+		var chunk [8]byte
+		binary.BigEndian.PutUint64(chunk[:], v)
+		copy(key[(n-1)*limbBytes:], chunk[1:])
+	}
+
+	// NOTE: Anything past the limbs of the key, including the padding of
+	// the last limb, must be zero for the sum to hold a single key.
+	for _, b := range key[size:] {
+		if b != 0 {
+			return nil, false
+		}
+	}
+
+	for _, b := range slot[8*limbs:] {
+		if b != 0 {
+			return nil, false
+		}
+	}
+
+	return key[:size], true
+}
+
+// reduceDigest returns the digest with both halves reduced to field elements.
+func reduceDigest(digest [2]uint64) [2]uint64 {
+	return [2]uint64{reduce(digest[0]), reduce(digest[1])}
+}
+
+// add adds the key with the given digest, weighted by the count, to cell j.
+func (c *cells) add(j uint64, key []byte, digest [2]uint64, count int64) {
+	multiplicity := fromCount(count)
+	digest = reduceDigest(digest)
+
+	c.keys.add(j, key, multiplicity)
+
+	c.digests[j][0] = addMod(c.digests[j][0], mulMod(digest[0], multiplicity))
+	c.digests[j][1] = addMod(c.digests[j][1], mulMod(digest[1], multiplicity))
+	c.counts[j] += count
+}
+
+// combineMod adds (sign 1) or subtracts (sign -1) the other cells to these.
+func (c *cells) combineMod(other *cells, sign int64) {
+	c.keys.combineMod(&other.keys, sign)
+
+	for j := range c.counts {
+		c.counts[j] += sign * other.counts[j]
+
+		for h := range c.digests[j] {
+			v := other.digests[j][h]
+			if sign < 0 {
+				v = negMod(v)
+			}
+
+			c.digests[j][h] = addMod(c.digests[j][h], v)
+		}
+	}
+}
+
+// addCellMod adds cell j of the other cells to cell t.
+func (c *cells) addCellMod(t uint64, other *cells, j uint64) {
+	c.keys.reserve(other.keys.width)
+
+	slot, from := c.keys.slot(t), other.keys.slot(j)
+	for n := 0; n+8 <= len(from); n += 8 {
+		binary.BigEndian.PutUint64(slot[n:], addMod(binary.BigEndian.Uint64(slot[n:]), binary.BigEndian.Uint64(from[n:])))
+	}
+
+	c.counts[t] += other.counts[j]
+	c.digests[t][0] = addMod(c.digests[t][0], other.digests[j][0])
+	c.digests[t][1] = addMod(c.digests[t][1], other.digests[j][1])
+}
+
+// negate negates the counts and the sums.
+func (c *cells) negate() {
+	c.keys.negate()
+
+	for j := range c.counts {
+		c.counts[j] *= -1
+		c.digests[j][0] = negMod(c.digests[j][0])
+		c.digests[j][1] = negMod(c.digests[j][1])
+	}
+}
+
+// reduced returns true if every sum and digest is a field element.
+func (c *cells) reduced() bool {
+	for _, digest := range c.digests {
+		if digest[0] >= modulus || digest[1] >= modulus {
+			return false
+		}
+	}
+
+	return c.keys.reduced()
+}
+
+// unscale returns the key in cell j assuming the cell holds a single key with
+// a multiplicity equal to its count.
+func (c *cells) unscale(j uint64) (key []byte, ok bool) {
+	if c.counts[j] == 0 {
+		return nil, false
+	}
+
+	return c.keys.unscale(j, fromCount(c.counts[j]))
+}
@@ -0,0 +1,65 @@
+package ibf
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiset(t *testing.T) {
+	t.Run("field", func(t *testing.T) {
+		p := big.NewInt(modulus)
+		rng := rand.New(rand.NewSource(1))
+
+		values := []uint64{0, 1, 2, modulus - 1, modulus - 2}
+		for j := 0; j < 100; j++ {
+			values = append(values, reduce(rng.Uint64()))
+		}
+
+		for _, a := range values {
+			for _, b := range values {
+				expected := new(big.Int).Mul(new(big.Int).SetUint64(a), new(big.Int).SetUint64(b))
+				expected.Mod(expected, p)
+				require.Equal(t, expected.Uint64(), mulMod(a, b))
+
+				expected = new(big.Int).Add(new(big.Int).SetUint64(a), new(big.Int).SetUint64(b))
+				expected.Mod(expected, p)
+				require.Equal(t, expected.Uint64(), addMod(a, b))
+			}
+
+			require.Equal(t, uint64(0), addMod(a, negMod(a)))
+
+			if a != 0 {
+				require.Equal(t, uint64(1), mulMod(a, invMod(a)))
+			}
+		}
+
+		require.Equal(t, uint64(0), reduce(modulus))
+		require.Equal(t, new(big.Int).Mod(new(big.Int).SetUint64(^uint64(0)), p).Uint64(), reduce(^uint64(0)))
+		require.Equal(t, negMod(3), fromCount(-3))
+	})
+
+	t.Run("limbs", func(t *testing.T) {
+		for n := 0; n < 30; n++ {
+			key := make([]byte, n)
+			for j := range key {
+				key[j] = byte(j + 1)
+			}
+
+			for _, count := range []int64{1, -1, 5, -7} {
+				s := newSums(1, 0)
+				s.add(0, key, fromCount(count))
+
+				unscaled, ok := s.unscale(0, fromCount(count))
+				require.True(t, ok)
+				require.Equal(t, key, unscaled)
+
+				// Removing the key leaves the sum zero.
+				s.add(0, key, negMod(fromCount(count)))
+				require.True(t, s.isZero(0))
+			}
+		}
+	})
+}
@@ -0,0 +1,225 @@
+package ibf
+
+// Placement selects how the cells a key occupies are chosen.
+type Placement uint8
+
+// Placements supported by IBF.
+const (
+	// PlacementProbe lets every positioner index the whole IBF. When two
+	// positioners pick the same cell the following cells are probed until
+	// an unused one is found.
+	PlacementProbe Placement = iota
+
+	// PlacementPartitioned splits the IBF into one equal sub-table per
+	// positioner and each positioner only indexes its own sub-table. The
+	// positions of a key are distinct by construction.
+	PlacementPartitioned
+
+	// PlacementModulo takes each positioner's hash modulo the size
+	// without probing, so an IBF can be folded to any size dividing its
+	// own. Two positioners picking the same cell put the key in it twice,
+	// which cancels the key out of the XOR sums but keeps the cell
+	// consistent. Multisets would count the key twice instead, so they
+	// can't use this placement.
+	PlacementModulo
+)
+
+var placementNames = map[Placement]string{
+	PlacementProbe:       "probe",
+	PlacementPartitioned: "partitioned",
+	PlacementModulo:      "modulo",
+}
+
+// String returns the name of the placement.
+func (p Placement) String() string {
+	name, ok := placementNames[p]
+	if !ok {
+		return "unknown"
+	}
+
+	return name
+}
+
+// ParsePlacement returns the placement with the given name.
+func ParsePlacement(name string) (Placement, error) {
+	for p, n := range placementNames {
+		if n == name {
+			return p, nil
+		}
+	}
+
+	return 0, Error.New("unknown placement %q", name)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (p Placement) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (p *Placement) UnmarshalText(text []byte) (err error) {
+	*p, err = ParsePlacement(string(text))
+
+	return err
+}
+
+// Scheme selects how the positions and digest of a key are hashed.
+type Scheme uint8
+
+// Schemes supported by IBF.
+const (
+	// SchemeIndependent hashes the key once with each positioner and once
+	// with the hasher.
+	SchemeIndependent Scheme = iota
+
+	// SchemeDouble hashes the key once with a 128-bit keyed hash and
+	// derives the positions and the digest from it using double hashing
+	// (Kirsch–Mitzenmacher). The positioners are not used.
+	SchemeDouble
+)
+
+var schemeNames = map[Scheme]string{
+	SchemeIndependent: "independent",
+	SchemeDouble:      "double",
+}
+
+// String returns the name of the scheme.
+func (s Scheme) String() string {
+	name, ok := schemeNames[s]
+	if !ok {
+		return "unknown"
+	}
+
+	return name
+}
+
+// ParseScheme returns the scheme with the given name.
+func ParseScheme(name string) (Scheme, error) {
+	for s, n := range schemeNames {
+		if n == name {
+			return s, nil
+		}
+	}
+
+	return 0, Error.New("unknown scheme %q", name)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Scheme) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *Scheme) UnmarshalText(text []byte) (err error) {
+	*s, err = ParseScheme(string(text))
+
+	return err
+}
+
+// Options holds the parameters of a new IBF beyond its size and seed.
+type Options struct {
+	// Hashes is the number of positioners (k) and so the number of cells
+	// each key is placed in.
+	Hashes int
+
+	// Placement selects how the cells for a key are chosen.
+	Placement Placement
+
+	// Scheme selects how the positions and digest of a key are hashed.
+	Scheme Scheme
+
+	// DigestBits is the width of the digest stored in each cell and used
+	// to check that a cell holds a single key. It is either 64 or 128. If
+	// zero 64 is used.
+	DigestBits int
+
+	// KeyWidth fixes the width of every key in bytes. Keys are then
+	// stored without their length and keys of other widths are rejected.
+	// If zero keys can have any length.
+	KeyWidth int
+
+	// HashKeys replaces every element by its KeyDigest before it is
+	// stored, so the cells only ever hold KeyDigestSize bytes no matter
+	// how large the elements are. Decoding returns the digests. The key
+	// width is set to KeyDigestSize.
+	HashKeys bool
+
+	// Multiset makes the IBF count repeated keys instead of cancelling
+	// them out, so inserting a key twice stores it with a multiplicity of
+	// two. Decoding returns each key with its multiplicity. It can't be
+	// combined with PlacementModulo.
+	Multiset bool
+
+	// Hash is the name of the registered hash algorithm used for the
+	// positioners and the hasher. If empty DefaultHasher is used.
+	Hash string
+}
+
+// DefaultOptions are the options used by NewIBF.
+var DefaultOptions = Options{
+	Hashes:    3,
+	Placement: PlacementProbe,
+	Scheme:    SchemeIndependent,
+	Hash:      DefaultHasher,
+
+	DigestBits: 64,
+}
+
+// hash returns the name of the hash algorithm to use.
+func (o Options) hash() string {
+	if o.Hash == "" {
+		return DefaultHasher
+	}
+
+	return o.Hash
+}
+
+// validate returns an error if the options can't be used with the size.
+func (o Options) validate(size uint64) error {
+	if o.Hashes < 1 || uint64(o.Hashes) > size {
+		return Error.New("hashes must be between 1 and the size (%d): %d", size, o.Hashes)
+	}
+
+	switch o.Placement {
+	case PlacementProbe, PlacementModulo:
+	case PlacementPartitioned:
+		if size%uint64(o.Hashes) != 0 {
+			return Error.New("partitioned placement requires the size (%d) to be a multiple of hashes (%d)", size, o.Hashes)
+		}
+	default:
+		return Error.New("unknown placement %d", o.Placement)
+	}
+
+	// NOTE: A key placed twice in a cell has twice the weight in the sums
+	// of a multiset, which makes it decode with the wrong count or keeps
+	// decoding from ever running out of pure cells.
+	if o.Multiset && o.Placement == PlacementModulo {
+		return Error.New("multisets can't use the modulo placement")
+	}
+
+	switch o.Scheme {
+	case SchemeIndependent, SchemeDouble:
+	default:
+		return Error.New("unknown scheme %d", o.Scheme)
+	}
+
+	switch o.DigestBits {
+	case 0, 64, 128:
+	default:
+		return Error.New("digest bits must be 64 or 128: %d", o.DigestBits)
+	}
+
+	if o.KeyWidth < 0 {
+		return Error.New("key width must not be negative: %d", o.KeyWidth)
+	}
+
+	if o.HashKeys && o.KeyWidth != 0 && o.KeyWidth != KeyDigestSize {
+		return Error.New("hashed keys require a key width of %d: %d", KeyDigestSize, o.KeyWidth)
+	}
+
+	if _, ok := lookupHasher(o.hash()); !ok {
+		return Error.New("unknown hasher %q", o.hash())
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package ibf
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"sort"
+)
+
+// Patch turns a file into a target file split into blocks of BlockSize bytes.
+// It holds the blocks of the target that the file does not have at the same
+// place along with the length and the SHA-256 digest of the target. All other
+// blocks are copied from the file.
+type Patch struct {
+	BlockSize uint64
+	Length    uint64
+	Digest    []byte
+
+	// Blocks maps the number of a block, counted from zero, to its
+	// contents.
+	Blocks map[uint64][]byte
+}
+
+// MaxPatchBlockSize is the largest block size of a patch.
+const MaxPatchBlockSize = 1 << 30
+
+// GetBlockCount returns the number of blocks of the target.
+func (p *Patch) GetBlockCount() uint64 {
+	if p.BlockSize == 0 {
+		return 0
+	}
+
+	count := p.Length / p.BlockSize
+	if p.Length%p.BlockSize != 0 {
+		count++
+	}
+
+	return count
+}
+
+// getBlockSize returns the size of block j of the target.
+func (p *Patch) getBlockSize(j uint64) uint64 {
+	if rest := p.Length - j*p.BlockSize; rest < p.BlockSize {
+		return rest
+	}
+
+	return p.BlockSize
+}
+
+// validate returns an error if the patch can't describe a target.
+func (p *Patch) validate() error {
+	switch {
+	case p.BlockSize == 0 && p.Length > 0:
+		return Error.New("invalid block size 0")
+	case p.BlockSize > MaxPatchBlockSize:
+		return Error.New("block size %d is larger than %d", p.BlockSize, MaxPatchBlockSize)
+	case len(p.Digest) != sha256.Size:
+		return Error.New("invalid digest length %d", len(p.Digest))
+	}
+
+	count := p.GetBlockCount()
+
+	for j, block := range p.Blocks {
+		if j >= count {
+			return Error.New("block %d is outside of the target of %d blocks", j, count)
+		}
+
+		if size := p.getBlockSize(j); uint64(len(block)) != size {
+			return Error.New("block %d is %d bytes long, expected %d", j, len(block), size)
+		}
+	}
+
+	return nil
+}
+
+// Apply writes the target to w reading the blocks missing from the patch from
+// old. The result is checked against the digest once written, so w should be
+// discarded if ErrIntegrity is returned.
+func (p *Patch) Apply(old io.ReaderAt, w io.Writer) (err error) {
+	err = p.validate()
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	w = io.MultiWriter(w, h)
+
+	count := p.GetBlockCount()
+
+	// NOTE: The buffer is only as large as the target needs.
+	buf := make([]byte, p.getBlockSize(0))
+
+	for j := uint64(0); j < count; j++ {
+		size := p.getBlockSize(j)
+
+		block, ok := p.Blocks[j]
+		if !ok {
+			// NOTE: ReadAt may return io.EOF along with a
+			// complete read of the last block.
+			n, err := old.ReadAt(buf[:size], int64(j*p.BlockSize))
+			if uint64(n) < size {
+				if err == nil || err == io.EOF {
+					err = Error.New("block %d is missing from the file", j)
+				}
+
+				return err
+			}
+
+			block = buf[:size]
+		}
+
+		_, err = w.Write(block)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !bytes.Equal(h.Sum(nil), p.Digest) {
+		return ErrIntegrity
+	}
+
+	return nil
+}
+
+// MarshalBinary encodes the patch in the compact binary format.
+func (p *Patch) MarshalBinary() (data []byte, err error) {
+	err = p.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	e := newEncoder(kindPatch)
+	e.uvarint(p.BlockSize)
+	e.uvarint(p.Length)
+	e.bytes(p.Digest)
+
+	indices := make([]uint64, 0, len(p.Blocks))
+	for j := range p.Blocks {
+		indices = append(indices, j)
+	}
+
+	sort.Slice(indices, func(a, b int) bool { return indices[a] < indices[b] })
+
+	e.uvarint(uint64(len(indices)))
+
+	for _, j := range indices {
+		e.uvarint(j)
+		e.bytes(p.Blocks[j])
+	}
+
+	return e.buf, nil
+}
+
+// UnmarshalBinary decodes a patch encoded by MarshalBinary.
+func (p *Patch) UnmarshalBinary(data []byte) (err error) {
+	kind, data, err := decodeHeader(data)
+	if err != nil {
+		return err
+	}
+
+	if kind != kindPatch {
+		return Error.New("not a patch")
+	}
+
+	d := &decoder{data: data}
+	u := Patch{
+		BlockSize: d.uvarint(),
+		Length:    d.uvarint(),
+		Digest:    copyBytes(d.bytes()),
+		Blocks:    map[uint64][]byte{},
+	}
+
+	count := d.uvarint()
+	if d.err == nil && count > uint64(len(d.data)) {
+		return Error.New("invalid block count %d", count)
+	}
+
+	for j := uint64(0); j < count && d.err == nil; j++ {
+		index := d.uvarint()
+		u.Blocks[index] = copyBytes(d.bytes())
+	}
+
+	err = d.done()
+	if err != nil {
+		return err
+	}
+
+	err = u.validate()
+	if err != nil {
+		return err
+	}
+
+	*p = u
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package ibf
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPatch(t *testing.T) {
+	old := []byte("aaaabbbbccccdddd")
+	target := []byte("aaaaBBBBccccddddee")
+	digest := sha256.Sum256(target)
+
+	p := &Patch{
+		BlockSize: 4,
+		Length:    uint64(len(target)),
+		Digest:    digest[:],
+		Blocks: map[uint64][]byte{
+			1: []byte("BBBB"),
+			4: []byte("ee"),
+		},
+	}
+
+	t.Run("apply", func(t *testing.T) {
+		require.Equal(t, uint64(5), p.GetBlockCount())
+
+		var buf bytes.Buffer
+		require.NoError(t, p.Apply(bytes.NewReader(old), &buf))
+		require.Equal(t, target, buf.Bytes())
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		digest := sha256.Sum256(old[:6])
+
+		p := &Patch{
+			BlockSize: 4,
+			Length:    6,
+			Digest:    digest[:],
+		}
+
+		var buf bytes.Buffer
+		require.NoError(t, p.Apply(bytes.NewReader(old), &buf))
+		require.Equal(t, old[:6], buf.Bytes())
+	})
+
+	t.Run("integrity", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := p.Apply(bytes.NewReader([]byte("aaaabbbbXXXXdddd")), &buf)
+		require.Equal(t, ErrIntegrity, err)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := p.Apply(bytes.NewReader(old[:10]), &buf)
+		require.Error(t, err)
+		require.NotEqual(t, ErrIntegrity, err)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for name, invalid := range map[string]*Patch{
+			"block size 0":   {BlockSize: 0, Length: 1, Digest: digest[:]},
+			"huge block":     {BlockSize: 1 << 62, Length: 1, Digest: digest[:]},
+			"digest":         {BlockSize: 4, Length: 1, Digest: digest[:8]},
+			"block index":    {BlockSize: 4, Length: 8, Digest: digest[:], Blocks: map[uint64][]byte{2: []byte("aaaa")}},
+			"block length":   {BlockSize: 4, Length: 8, Digest: digest[:], Blocks: map[uint64][]byte{0: []byte("a")}},
+			"last block len": {BlockSize: 4, Length: 6, Digest: digest[:], Blocks: map[uint64][]byte{1: []byte("aaaa")}},
+		} {
+			_, err := invalid.MarshalBinary()
+			require.Error(t, err, name)
+
+			require.Error(t, invalid.Apply(bytes.NewReader(old), &bytes.Buffer{}), name)
+
+			// Encoded by hand since MarshalBinary refuses.
+			e := newEncoder(kindPatch)
+			e.uvarint(invalid.BlockSize)
+			e.uvarint(invalid.Length)
+			e.bytes(invalid.Digest)
+			e.uvarint(uint64(len(invalid.Blocks)))
+			for j, block := range invalid.Blocks {
+				e.uvarint(j)
+				e.bytes(block)
+			}
+
+			require.Error(t, (&Patch{}).UnmarshalBinary(e.buf), name)
+		}
+	})
+
+	t.Run("encoding", func(t *testing.T) {
+		data, err := p.MarshalBinary()
+		require.NoError(t, err)
+
+		decoded := &Patch{}
+		require.NoError(t, decoded.UnmarshalBinary(data))
+		require.Equal(t, p, decoded)
+
+		for n := 0; n < len(data); n++ {
+			require.Error(t, (&Patch{}).UnmarshalBinary(data[:n]), n)
+		}
+
+		data, err = NewIBF(10, 1).MarshalBinary()
+		require.NoError(t, err)
+		require.Error(t, decoded.UnmarshalBinary(data))
+	})
+}
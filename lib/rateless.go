@@ -0,0 +1,319 @@
+package ibf
+
+import (
+	"container/heap"
+	"math"
+)
+
+// A rateless encoder turns a set into an unbounded stream of coded symbols,
+// following "Practical Rateless Set Reconciliation" (Yang et al., 2024). Each
+// symbol is a Cell holding the XOR of the keys mapped to it. Every key is
+// mapped to symbol 0 and then to a pseudo-random sequence of later symbols
+// that becomes sparser along the stream, so any prefix of the stream can be
+// peeled like an IBF. A receiver subtracts the stream of its own set and
+// peels the difference as soon as it has received enough symbols, roughly
+// 1.35 times the size of the difference.
+
+// mapping generates the indices of the symbols a key is mapped to.
+type mapping struct {
+	prng  uint64
+	index uint64
+}
+
+// newMapping returns the mapping of the key with the digest. The first index
+// is always 0.
+func newMapping(digest uint64) mapping {
+	return mapping{prng: digest}
+}
+
+// next advances to the next index of the key. The gap to the next index grows
+// with the index so that symbol i holds each key with a probability of about
+// 1/(1+i/2).
+func (m *mapping) next() uint64 {
+	r := m.prng * 0xda942042e4dd58b5
+	m.prng = r
+
+	gap := math.Ceil((float64(m.index) + 1.5) * ((1<<32)/math.Sqrt(float64(r)+1) - 1))
+
+	// NOTE: Converting a float that doesn't fit is platform dependent,
+	// so gaps past the end of any realistic stream are clamped.
+	if gap >= float64(math.MaxUint64-m.index) {
+		m.index = math.MaxUint64
+
+		return m.index
+	}
+
+	m.index += uint64(gap)
+
+	return m.index
+}
+
+// codedKey is a key together with its position in its mapping.
+type codedKey struct {
+	key     []byte
+	digest  uint64
+	count   int64
+	mapping mapping
+}
+
+// keyHeap orders coded keys by the next index they are mapped to.
+type keyHeap []*codedKey
+
+func (h keyHeap) Len() int            { return len(h) }
+func (h keyHeap) Less(a, b int) bool  { return h[a].mapping.index < h[b].mapping.index }
+func (h keyHeap) Swap(a, b int)       { h[a], h[b] = h[b], h[a] }
+func (h *keyHeap) Push(v interface{}) { *h = append(*h, v.(*codedKey)) }
+
+func (h *keyHeap) Pop() interface{} {
+	old := *h
+	v := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return v
+}
+
+// apply adds (or removes for a negative count) every key mapped to the symbol
+// at the index and advances those keys to their next index.
+func (h *keyHeap) apply(index uint64, symbol *Cell) {
+	for h.Len() > 0 && (*h)[0].mapping.index == index {
+		k := (*h)[0]
+
+		if k.count > 0 {
+			symbol.Insert(k.key, k.digest)
+		} else {
+			symbol.Remove(k.key, k.digest)
+		}
+
+		k.mapping.next()
+		heap.Fix(h, 0)
+	}
+}
+
+// Encoder produces the coded symbol stream of a set.
+type Encoder struct {
+	Hasher Hasher
+
+	keys keyHeap
+	next uint64
+}
+
+// NewEncoder creates an encoder whose hasher is created using the output from
+// a random number generator initialized with the seed. Both sides of a
+// reconciliation must use the same seed.
+func NewEncoder(seed int64) *Encoder {
+	// NOTE: DefaultHasher is registered by this package and hashers can't
+	// be unregistered, so this only fails if that invariant is broken.
+	hasher, err := NewSeededHasher(DefaultHasher, seed)
+	if err != nil {
+		panic(err)
+	}
+
+	return NewEncoderWithHash(hasher)
+}
+
+// NewEncoderWithHash creates an encoder using the hasher for the key digests.
+func NewEncoderWithHash(hasher Hasher) *Encoder {
+	return &Encoder{
+		Hasher: hasher,
+	}
+}
+
+// Add adds the key to the set. Keys must all be added before the first
+// symbol is produced, otherwise it returns an error.
+//
+// NOTE: Like IBF.Insert this does not know if the key was already added.
+// Adding it twice cancels the key and its digest out of the symbols but counts
+// it twice, so the stream can't be fully decoded.
+func (e *Encoder) Add(key []byte) error {
+	if e.next > 0 {
+		return Error.New("keys must be added before symbols are produced")
+	}
+
+	digest := e.Hasher.Hash(key)
+
+	heap.Push(&e.keys, &codedKey{
+		key:     copyBytes(key),
+		digest:  digest,
+		count:   1,
+		mapping: newMapping(digest),
+	})
+
+	return nil
+}
+
+// Next returns the next coded symbol of the stream.
+func (e *Encoder) Next() *Cell {
+	symbol := NewCell()
+
+	e.keys.apply(e.next, symbol)
+	e.next++
+
+	return symbol
+}
+
+// GetSymbolCount returns the number of symbols produced so far.
+func (e *Encoder) GetSymbolCount() uint64 {
+	return e.next
+}
+
+// Decoder reconciles a local set with the coded symbol stream of a remote set.
+// The local set is added first, then the remote symbols are added in order
+// until Decoded returns true.
+type Decoder struct {
+	local *Encoder
+
+	// symbols holds the local symbols minus the remote symbols with the
+	// decoded keys peeled off.
+	symbols []*Cell
+
+	// decoded holds the decoded keys so that they can be peeled off the
+	// symbols that have yet to arrive.
+	decoded keyHeap
+
+	diff Difference
+}
+
+// NewDecoder creates a decoder for a stream produced by an encoder created
+// with the same seed.
+func NewDecoder(seed int64) *Decoder {
+	return NewDecoderWithHash(NewEncoder(seed).Hasher)
+}
+
+// NewDecoderWithHash creates a decoder for a stream produced by an encoder
+// using the same hasher.
+func NewDecoderWithHash(hasher Hasher) *Decoder {
+	return &Decoder{
+		local: NewEncoderWithHash(hasher),
+	}
+}
+
+// Add adds the key to the local set. Keys must all be added before the first
+// symbol, otherwise it returns an error.
+func (d *Decoder) Add(key []byte) error {
+	return d.local.Add(key)
+}
+
+// AddSymbol adds the next symbol of the remote stream and peels every key it
+// allows to be recovered.
+func (d *Decoder) AddSymbol(symbol *Cell) {
+	index := uint64(len(d.symbols))
+
+	s := d.local.Next()
+	s.Subtract(symbol)
+
+	// NOTE: Keys decoded earlier are peeled off as their symbols arrive.
+	// The peeled symbol holds the key with the opposite count.
+	d.decoded.apply(index, s)
+
+	d.symbols = append(d.symbols, s)
+
+	d.peel(index)
+}
+
+// isPure returns true if the symbol at the index holds exactly one key,
+// either only local (count 1) or only remote (count -1).
+func (d *Decoder) isPure(index uint64) bool {
+	s := d.symbols[index]
+
+	if s.Count == 1 || s.Count == -1 {
+		return s.DigestHi == 0 && s.Digest == d.local.Hasher.Hash(s.Key.Value())
+	}
+
+	return false
+}
+
+// peel decodes keys starting from the symbol at the index.
+func (d *Decoder) peel(index uint64) {
+	queue := []uint64{index}
+
+	for len(queue) > 0 {
+		j := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+
+		if !d.isPure(j) {
+			continue
+		}
+
+		s := d.symbols[j]
+		k := &codedKey{
+			key:     s.GetKey(),
+			digest:  s.Digest,
+			mapping: newMapping(s.Digest),
+		}
+
+		// NOTE: A key whose digest collides with the symbol's must
+		// still be mapped to the symbol. Peeling it otherwise would
+		// corrupt the other symbols.
+		for k.mapping.index < j {
+			k.mapping.next()
+		}
+		if k.mapping.index != j {
+			continue
+		}
+
+		// NOTE: The key is peeled by adding it with the opposite count
+		// to every symbol it is mapped to.
+		k.count = -s.Count
+		k.mapping = newMapping(k.digest)
+
+		for ; k.mapping.index < uint64(len(d.symbols)); k.mapping.next() {
+			index := k.mapping.index
+			symbol := d.symbols[index]
+
+			if k.count > 0 {
+				symbol.Insert(k.key, k.digest)
+			} else {
+				symbol.Remove(k.key, k.digest)
+			}
+
+			if d.isPure(index) {
+				queue = append(queue, index)
+			}
+		}
+
+		heap.Push(&d.decoded, k)
+
+		if k.count < 0 {
+			d.diff.Left = append(d.diff.Left, k.key)
+			d.diff.LeftCounts = append(d.diff.LeftCounts, 1)
+		} else {
+			d.diff.Right = append(d.diff.Right, k.key)
+			d.diff.RightCounts = append(d.diff.RightCounts, -1)
+		}
+	}
+}
+
+// Decoded returns true once the difference has been completely recovered.
+// Every key is mapped to the first symbol, so this is the case when it is
+// empty.
+func (d *Decoder) Decoded() bool {
+	return len(d.symbols) > 0 && d.symbols[0].IsEmpty()
+}
+
+// GetSymbolCount returns the number of remote symbols added so far.
+func (d *Decoder) GetSymbolCount() uint64 {
+	return uint64(len(d.symbols))
+}
+
+// Difference returns the keys recovered so far. Left holds the keys only in
+// the local set and Right the keys only in the remote set. Until Decoded
+// returns true the difference may be incomplete and Remaining holds copies of
+// the symbols that are not empty.
+func (d *Decoder) Difference() *Difference {
+	diff := &Difference{
+		Left:        d.diff.Left,
+		Right:       d.diff.Right,
+		LeftCounts:  d.diff.LeftCounts,
+		RightCounts: d.diff.RightCounts,
+	}
+
+	if !d.Decoded() {
+		for _, s := range d.symbols {
+			if !s.IsEmpty() {
+				diff.Remaining = append(diff.Remaining, s.Clone())
+			}
+		}
+	}
+
+	return diff
+}
@@ -0,0 +1,196 @@
+package ibf
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateless(t *testing.T) {
+	reconcile := func(t *testing.T, local, remote []string) (diff *Difference, symbols uint64) {
+		e := NewEncoder(1)
+		for _, key := range remote {
+			require.NoError(t, e.Add([]byte(key)))
+		}
+
+		d := NewDecoder(1)
+		for _, key := range local {
+			require.NoError(t, d.Add([]byte(key)))
+		}
+
+		for !d.Decoded() {
+			require.True(t, d.GetSymbolCount() < 10000, "too many symbols")
+
+			d.AddSymbol(e.Next())
+		}
+
+		return d.Difference(), d.GetSymbolCount()
+	}
+
+	strs := func(keys [][]byte) (s []string) {
+		for _, key := range keys {
+			s = append(s, string(key))
+		}
+
+		sort.Strings(s)
+
+		return s
+	}
+
+	t.Run("reconcile", func(t *testing.T) {
+		local, remote := []string{}, []string{}
+		onlyLocal, onlyRemote := []string{}, []string{}
+
+		for j := 0; j < 1000; j++ {
+			key := strconv.Itoa(j)
+
+			switch {
+			case j < 40:
+				local = append(local, key)
+				onlyLocal = append(onlyLocal, key)
+			case j < 100:
+				remote = append(remote, key)
+				onlyRemote = append(onlyRemote, key)
+			default:
+				local = append(local, key)
+				remote = append(remote, key)
+			}
+		}
+
+		sort.Strings(onlyLocal)
+		sort.Strings(onlyRemote)
+
+		diff, symbols := reconcile(t, local, remote)
+		require.Equal(t, onlyLocal, strs(diff.Left))
+		require.Equal(t, onlyRemote, strs(diff.Right))
+		require.Empty(t, diff.Remaining)
+
+		// The overhead is around 1.35 for large differences and a bit
+		// more for small ones.
+		require.True(t, symbols < 300, "decoded after %d symbols", symbols)
+	})
+
+	t.Run("equal", func(t *testing.T) {
+		keys := []string{"a", "b", "c"}
+
+		diff, symbols := reconcile(t, keys, keys)
+		require.Empty(t, diff.Left)
+		require.Empty(t, diff.Right)
+		require.Equal(t, uint64(1), symbols)
+	})
+
+	t.Run("incomplete", func(t *testing.T) {
+		e := NewEncoder(1)
+		for j := 0; j < 100; j++ {
+			require.NoError(t, e.Add([]byte(strconv.Itoa(j))))
+		}
+
+		d := NewDecoder(1)
+		for j := 0; j < 10; j++ {
+			d.AddSymbol(e.Next())
+		}
+
+		require.False(t, d.Decoded())
+		require.NotEmpty(t, d.Difference().Remaining)
+
+		require.Error(t, e.Add([]byte("late")))
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		e := NewEncoder(1)
+		require.NoError(t, e.Add([]byte("a")))
+		require.NoError(t, e.Add([]byte("a")))
+
+		// The key cancels out but its count doesn't.
+		symbol := e.Next()
+		require.Equal(t, int64(2), symbol.Count)
+		require.Empty(t, symbol.GetKey())
+		require.Equal(t, uint64(0), symbol.Digest)
+
+		d := NewDecoder(1)
+		d.AddSymbol(symbol)
+
+		for j := 0; j < 100; j++ {
+			d.AddSymbol(e.Next())
+		}
+
+		require.False(t, d.Decoded())
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		hasher, err := NewSeededHasher("xxh3", 2)
+		require.NoError(t, err)
+
+		e := NewEncoderWithHash(hasher)
+		for j := 0; j < 20; j++ {
+			require.NoError(t, e.Add([]byte(strconv.Itoa(j))))
+		}
+
+		var buf bytes.Buffer
+
+		w, err := NewSymbolWriter(&buf, hasher, 0)
+		require.NoError(t, err)
+
+		symbols := []*Cell{}
+		boundaries := map[int]bool{buf.Len(): true}
+
+		for j := 0; j < 60; j++ {
+			symbol := e.Next()
+			symbols = append(symbols, symbol)
+
+			require.NoError(t, w.Write(symbol))
+			boundaries[buf.Len()] = true
+		}
+
+		data := buf.Bytes()
+
+		r, err := NewSymbolReader(bytes.NewReader(data))
+		require.NoError(t, err)
+		require.Equal(t, hasher, r.Hasher)
+		require.Equal(t, uint64(0), r.Start)
+
+		d := NewDecoderWithHash(r.Hasher)
+
+		for _, expected := range symbols {
+			symbol, err := r.Read()
+			require.NoError(t, err)
+			require.Equal(t, expected, symbol)
+
+			d.AddSymbol(symbol)
+		}
+
+		_, err = r.Read()
+		require.Equal(t, io.EOF, err)
+
+		require.True(t, d.Decoded())
+		require.Len(t, d.Difference().Right, 20)
+
+		// Truncated streams are detected unless they end between
+		// symbols.
+		for n := 0; n < len(data); n++ {
+			r, err := NewSymbolReader(bytes.NewReader(data[:n]))
+			if err != nil {
+				continue
+			}
+
+			for err == nil {
+				_, err = r.Read()
+			}
+
+			require.Equal(t, boundaries[n], err == io.EOF, "truncated to %d", n)
+		}
+
+		buf.Reset()
+
+		_, err = NewSymbolWriter(&buf, hasher, 30)
+		require.NoError(t, err)
+
+		r, err = NewSymbolReader(&buf)
+		require.NoError(t, err)
+		require.Equal(t, uint64(30), r.Start)
+	})
+}
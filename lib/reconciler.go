@@ -0,0 +1,570 @@
+package ibf
+
+import (
+	"sort"
+)
+
+// A reconciliation runs between an initiator and a responder exchanging
+// messages over a transport chosen by the caller:
+//
+//	initiator                  responder
+//	Params      ---------->
+//	            <----------    Params
+//	            <----------    Estimator, if both have one
+//	Request N   ---------->
+//	            <----------    IBF of size N
+//	Escalate M  ---------->    if size N did not decode
+//	            <----------    IBF of size M
+//	Result      ---------->    or Fail
+//
+// The parameters list the sizes each side has with the fingerprint of its
+// parameters at each size. The initiator requests the smallest common size
+// expected to hold the estimated difference, or the smallest common size
+// without estimators, and escalates to the next common size until one
+// decodes. Both sides end up with the difference.
+
+// MessageType identifies the kind of a message.
+type MessageType byte
+
+// Message types.
+const (
+	MessageParams MessageType = iota + 1
+	MessageEstimator
+	MessageRequest
+	MessageIBF
+	MessageEscalate
+	MessageResult
+	MessageFail
+)
+
+var messageTypeNames = map[MessageType]string{
+	MessageParams:    "params",
+	MessageEstimator: "estimator",
+	MessageRequest:   "request",
+	MessageIBF:       "ibf",
+	MessageEscalate:  "escalate",
+	MessageResult:    "result",
+	MessageFail:      "fail",
+}
+
+func (t MessageType) String() string {
+	name, ok := messageTypeNames[t]
+	if !ok {
+		return "unknown"
+	}
+
+	return name
+}
+
+// Message is a step of a reconciliation. Only the fields of its type are
+// set.
+type Message struct {
+	Type MessageType
+
+	// Sizes, Fingerprints and HasEstimator are set for MessageParams.
+	Sizes        []uint64
+	Fingerprints []uint64
+	HasEstimator bool
+
+	// Estimator is set for MessageEstimator.
+	Estimator *Strata
+
+	// Size is the size requested by MessageRequest and MessageEscalate,
+	// sent by MessageIBF and decoded for MessageResult.
+	Size uint64
+
+	// IBF is set for MessageIBF.
+	IBF *IBF
+
+	// Difference is set for MessageResult. Left holds the elements only
+	// the initiator has and Right the elements only the responder has.
+	Difference *Difference
+
+	// Reason is set for MessageFail.
+	Reason string
+}
+
+// Source provides a set at several sizes.
+type Source interface {
+	// GetSizes returns the sizes the set is available at from smallest
+	// to largest.
+	GetSizes() []uint64
+
+	// GetFingerprint returns the fingerprint of the parameters of the
+	// set at the size.
+	GetFingerprint(size uint64) uint64
+
+	// GetIBF returns a copy of the set at the size.
+	GetIBF(size uint64) (*IBF, error)
+}
+
+// Reconciler runs one side of a reconciliation. Each message received from
+// the peer is passed to Handle, which returns the messages to send back,
+// until Done returns true.
+type Reconciler struct {
+	source    Source
+	estimator *Strata
+	initiator bool
+
+	// sizes holds the common sizes and next the index of the next one to
+	// request.
+	sizes []uint64
+	next  int
+
+	// remoteEstimator is true if the peer announced an estimator.
+	remoteEstimator bool
+
+	started bool
+	done    bool
+	diff    *Difference
+	size    uint64
+	err     error
+}
+
+// NewInitiator returns the reconciler of the side that starts the
+// reconciliation and decodes the difference. The estimator is optional.
+func NewInitiator(source Source, estimator *Strata) *Reconciler {
+	return &Reconciler{
+		source:    source,
+		estimator: estimator,
+		initiator: true,
+	}
+}
+
+// NewResponder returns the reconciler of the side that answers an initiator.
+// The estimator is optional.
+func NewResponder(source Source, estimator *Strata) *Reconciler {
+	return &Reconciler{
+		source:    source,
+		estimator: estimator,
+	}
+}
+
+// Start returns the messages that open the reconciliation: the parameters of
+// an initiator and nothing for a responder.
+func (r *Reconciler) Start() []*Message {
+	r.started = true
+
+	if !r.initiator {
+		return nil
+	}
+
+	return []*Message{r.params()}
+}
+
+// Handle processes a message from the peer and returns the messages to send
+// in reply. If the reconciliation fails the replies hold a MessageFail for
+// the peer and the error is returned.
+func (r *Reconciler) Handle(m *Message) (replies []*Message, err error) {
+	if r.done {
+		return nil, Error.New("reconciliation is over")
+	}
+
+	if !r.started {
+		replies = r.Start()
+	}
+
+	if m.Type == MessageFail {
+		r.finish(nil, 0, ErrPeer.New("%s", m.Reason))
+
+		return replies, r.err
+	}
+
+	var reply []*Message
+
+	if r.initiator {
+		reply, err = r.handleInitiator(m)
+	} else {
+		reply, err = r.handleResponder(m)
+	}
+
+	replies = append(replies, reply...)
+
+	if err != nil {
+		r.finish(nil, 0, err)
+
+		return append(replies, &Message{Type: MessageFail, Reason: err.Error()}), err
+	}
+
+	return replies, nil
+}
+
+func (r *Reconciler) handleInitiator(m *Message) ([]*Message, error) {
+	switch {
+	case m.Type == MessageParams && r.sizes == nil:
+		r.sizes = r.commonSizes(m)
+		if len(r.sizes) == 0 {
+			return nil, Error.New("no common size with matching parameters")
+		}
+
+		r.remoteEstimator = m.HasEstimator
+
+		// NOTE: With both estimators the responder sends its own
+		// right after its parameters.
+		if r.estimator != nil && r.remoteEstimator {
+			return nil, nil
+		}
+
+		return []*Message{r.request(MessageRequest, 0)}, nil
+	case m.Type == MessageEstimator && r.sizes != nil && r.next == 0:
+		if m.Estimator == nil {
+			return nil, Error.New("missing estimator")
+		}
+
+		start, err := r.startSize(m.Estimator)
+		if err != nil {
+			return nil, err
+		}
+
+		return []*Message{r.request(MessageRequest, start)}, nil
+	case m.Type == MessageIBF && r.next > 0:
+		if m.IBF == nil || m.Size != r.sizes[r.next-1] {
+			return nil, Error.New("unexpected IBF of size %d", m.Size)
+		}
+
+		set, err := r.source.GetIBF(m.Size)
+		if err != nil {
+			return nil, err
+		}
+
+		err = set.Subtract(m.IBF)
+		if err != nil {
+			return nil, err
+		}
+
+		diff, err := set.Decode()
+		if err != nil {
+			if r.next == len(r.sizes) {
+				return nil, ErrTooLarge
+			}
+
+			return []*Message{r.request(MessageEscalate, r.next)}, nil
+		}
+
+		r.finish(diff, m.Size, nil)
+
+		return []*Message{{Type: MessageResult, Size: m.Size, Difference: diff}}, nil
+	}
+
+	return nil, Error.New("unexpected %s message", m.Type)
+}
+
+func (r *Reconciler) handleResponder(m *Message) ([]*Message, error) {
+	switch {
+	case m.Type == MessageParams && r.sizes == nil:
+		r.sizes = r.commonSizes(m)
+		r.remoteEstimator = m.HasEstimator
+
+		replies := []*Message{r.params()}
+
+		if r.estimator != nil && r.remoteEstimator {
+			replies = append(replies, &Message{Type: MessageEstimator, Estimator: r.estimator})
+		}
+
+		return replies, nil
+	case (m.Type == MessageRequest || m.Type == MessageEscalate) && r.sizes != nil:
+		if !containsSize(r.sizes, m.Size) {
+			return nil, Error.New("size %d is not a common size", m.Size)
+		}
+
+		set, err := r.source.GetIBF(m.Size)
+		if err != nil {
+			return nil, err
+		}
+
+		return []*Message{{Type: MessageIBF, Size: m.Size, IBF: set}}, nil
+	case m.Type == MessageResult && r.sizes != nil:
+		if m.Difference == nil {
+			return nil, Error.New("missing difference")
+		}
+
+		// NOTE: The result is from the point of view of the
+		// initiator, so the sides are swapped.
+		r.finish(m.Difference.Swap(), m.Size, nil)
+
+		return nil, nil
+	}
+
+	return nil, Error.New("unexpected %s message", m.Type)
+}
+
+// Done returns true once the reconciliation is over.
+func (r *Reconciler) Done() bool {
+	return r.done
+}
+
+// Result returns the difference and the size it was decoded with once the
+// reconciliation is over. Left holds the elements only this side has and
+// Right the elements only the peer has. If the reconciliation failed it
+// returns the error.
+func (r *Reconciler) Result() (diff *Difference, size uint64, err error) {
+	if !r.done {
+		return nil, 0, Error.New("reconciliation is not over")
+	}
+
+	return r.diff, r.size, r.err
+}
+
+func (r *Reconciler) finish(diff *Difference, size uint64, err error) {
+	r.done = true
+	r.diff = diff
+	r.size = size
+	r.err = err
+}
+
+// params returns the parameters of this side.
+func (r *Reconciler) params() *Message {
+	return NewParams(r.source, r.estimator != nil)
+}
+
+// NewParams returns the parameters message advertising the sizes of the
+// source, such as to describe a set before any reconciliation starts.
+func NewParams(source Source, hasEstimator bool) *Message {
+	m := &Message{
+		Type:         MessageParams,
+		Sizes:        source.GetSizes(),
+		HasEstimator: hasEstimator,
+	}
+
+	for _, size := range m.Sizes {
+		m.Fingerprints = append(m.Fingerprints, source.GetFingerprint(size))
+	}
+
+	return m
+}
+
+// request returns the message requesting the common size at the index.
+func (r *Reconciler) request(t MessageType, index int) *Message {
+	r.next = index + 1
+
+	return &Message{Type: t, Size: r.sizes[index]}
+}
+
+// commonSizes returns the sizes both sides have with the same parameters from
+// smallest to largest.
+func (r *Reconciler) commonSizes(remote *Message) (sizes []uint64) {
+	fingerprints := map[uint64]uint64{}
+	for j, size := range remote.Sizes {
+		if j < len(remote.Fingerprints) {
+			fingerprints[size] = remote.Fingerprints[j]
+		}
+	}
+
+	for _, size := range r.source.GetSizes() {
+		fingerprint, ok := fingerprints[size]
+		if ok && fingerprint == r.source.GetFingerprint(size) {
+			sizes = append(sizes, size)
+		}
+	}
+
+	sort.Slice(sizes, func(a, b int) bool { return sizes[a] < sizes[b] })
+
+	return sizes
+}
+
+// startSize returns the index of the smallest common size expected to decode
+// the difference estimated with the remote estimator.
+func (r *Reconciler) startSize(remote *Strata) (int, error) {
+	estimate, err := r.estimator.Estimate(remote)
+	if ErrIncompatible.Has(err) {
+		return 0, err
+	}
+
+	// NOTE: If even the sparsest stratum can't be decoded the difference
+	// is huge and only the largest size has a chance.
+	if err != nil {
+		return len(r.sizes) - 1, nil
+	}
+
+	// NOTE: An IBF decodes reliably with about 1.5 cells per element of
+	// the difference.
+	for j, size := range r.sizes {
+		if size >= estimate+estimate/2 {
+			return j, nil
+		}
+	}
+
+	return len(r.sizes) - 1, nil
+}
+
+// containsSize returns true if the sizes contain the size.
+func containsSize(sizes []uint64, size uint64) bool {
+	for _, s := range sizes {
+		if s == size {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MarshalBinary encodes the message in the compact binary format so that it
+// can be sent over any transport.
+func (m *Message) MarshalBinary() (data []byte, err error) {
+	e := newEncoder(kindMessage)
+	e.uvarint(uint64(m.Type))
+
+	switch m.Type {
+	case MessageParams:
+		if len(m.Fingerprints) != len(m.Sizes) {
+			return nil, Error.New("%d sizes with %d fingerprints", len(m.Sizes), len(m.Fingerprints))
+		}
+
+		e.uvarint(uint64(len(m.Sizes)))
+
+		for j, size := range m.Sizes {
+			e.uvarint(size)
+			e.uint64(m.Fingerprints[j])
+		}
+
+		if m.HasEstimator {
+			e.uvarint(1)
+		} else {
+			e.uvarint(0)
+		}
+	case MessageEstimator:
+		if m.Estimator == nil {
+			return nil, Error.New("missing estimator")
+		}
+
+		data, err := m.Estimator.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+
+		e.bytes(data)
+	case MessageRequest, MessageEscalate:
+		e.uvarint(m.Size)
+	case MessageIBF:
+		if m.IBF == nil {
+			return nil, Error.New("missing IBF")
+		}
+
+		data, err := m.IBF.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+
+		e.uvarint(m.Size)
+		e.bytes(data)
+	case MessageResult:
+		if m.Difference == nil {
+			return nil, Error.New("missing difference")
+		}
+
+		e.uvarint(m.Size)
+		encodeKeys(e, m.Difference.Left, m.Difference.LeftCounts)
+		encodeKeys(e, m.Difference.Right, m.Difference.RightCounts)
+	case MessageFail:
+		e.bytes([]byte(m.Reason))
+	default:
+		return nil, Error.New("unknown message type %d", m.Type)
+	}
+
+	return e.buf, nil
+}
+
+// UnmarshalBinary decodes a message encoded by MarshalBinary.
+func (m *Message) UnmarshalBinary(data []byte) (err error) {
+	kind, data, err := decodeHeader(data)
+	if err != nil {
+		return err
+	}
+
+	if kind != kindMessage {
+		return Error.New("not a message")
+	}
+
+	d := &decoder{data: data}
+	u := Message{Type: MessageType(d.uvarint())}
+
+	switch u.Type {
+	case MessageParams:
+		count := d.uvarint()
+		if d.err == nil && count > uint64(len(d.data)) {
+			return Error.New("invalid size count %d", count)
+		}
+
+		for j := uint64(0); j < count && d.err == nil; j++ {
+			u.Sizes = append(u.Sizes, d.uvarint())
+			u.Fingerprints = append(u.Fingerprints, d.uint64())
+		}
+
+		u.HasEstimator = d.uvarint() != 0
+	case MessageEstimator:
+		data := d.bytes()
+		if d.err == nil {
+			u.Estimator = &Strata{}
+
+			err = u.Estimator.UnmarshalBinary(data)
+			if err != nil {
+				return err
+			}
+		}
+	case MessageRequest, MessageEscalate:
+		u.Size = d.uvarint()
+	case MessageIBF:
+		u.Size = d.uvarint()
+
+		data := d.bytes()
+		if d.err == nil {
+			u.IBF = &IBF{}
+
+			err = u.IBF.UnmarshalBinary(data)
+			if err != nil {
+				return err
+			}
+		}
+	case MessageResult:
+		u.Size = d.uvarint()
+		u.Difference = &Difference{}
+		u.Difference.Left, u.Difference.LeftCounts = decodeKeys(d)
+		u.Difference.Right, u.Difference.RightCounts = decodeKeys(d)
+	case MessageFail:
+		u.Reason = string(d.bytes())
+	default:
+		if d.err == nil {
+			return Error.New("unknown message type %d", u.Type)
+		}
+	}
+
+	err = d.done()
+	if err != nil {
+		return err
+	}
+
+	*m = u
+
+	return nil
+}
+
+// encodeKeys encodes the keys with their counts. Missing counts are encoded
+// as zero.
+func encodeKeys(e *encoder, keys [][]byte, counts []int64) {
+	e.uvarint(uint64(len(keys)))
+
+	for j, key := range keys {
+		var count int64
+		if j < len(counts) {
+			count = counts[j]
+		}
+
+		e.bytes(key)
+		e.varint(count)
+	}
+}
+
+// decodeKeys decodes keys encoded by encodeKeys.
+func decodeKeys(d *decoder) (keys [][]byte, counts []int64) {
+	count := d.uvarint()
+	if d.err == nil && count > uint64(len(d.data)) {
+		d.fail(Error.New("invalid key count %d", count))
+	}
+
+	for j := uint64(0); j < count && d.err == nil; j++ {
+		keys = append(keys, copyBytes(d.bytes()))
+		counts = append(counts, d.varint())
+	}
+
+	return keys, counts
+}
@@ -0,0 +1,214 @@
+package ibf
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// pipe connects two reconcilers in memory. Every message is sent through its
+// binary encoding, as a real transport would.
+type pipe struct {
+	messages []MessageType
+	bytes    int
+}
+
+// run exchanges messages until neither side has anything left to send.
+func (p *pipe) run(t *testing.T, initiator, responder *Reconciler) {
+	peers := [2]*Reconciler{initiator, responder}
+	queues := [2][]*Message{nil, initiator.Start()}
+
+	// NOTE: The responder starts with the messages of the initiator in
+	// its queue, so it delivers first.
+	for j := 1; len(queues[0]) > 0 || len(queues[1]) > 0; j = 1 - j {
+		queue := queues[j]
+		queues[j] = nil
+
+		for _, m := range queue {
+			data, err := m.MarshalBinary()
+			require.NoError(t, err)
+
+			received := &Message{}
+			require.NoError(t, received.UnmarshalBinary(data))
+
+			p.messages = append(p.messages, received.Type)
+			p.bytes += len(data)
+
+			replies, _ := peers[j].Handle(received)
+			queues[1-j] = append(queues[1-j], replies...)
+		}
+	}
+}
+
+// simulate reconciles two bundles sharing 1000 keys, with delta keys split
+// between them, and returns both reconcilers along with the pipe.
+func simulate(t *testing.T, delta int, estimators bool) (initiator, responder *Reconciler, p *pipe) {
+	b0, err := NewBundle([]uint64{20, 60, 200, 600}, 1, DefaultOptions)
+	require.NoError(t, err)
+
+	b1 := b0.Clone()
+
+	var s0, s1 *Strata
+	if estimators {
+		s0, s1 = NewStrata(1), NewStrata(1)
+	}
+
+	insert := func(b *Bundle, s *Strata, key []byte) {
+		require.NoError(t, b.Insert(key))
+
+		if s != nil {
+			require.NoError(t, s.Insert(key))
+		}
+	}
+
+	for j := 0; j < 1000+delta; j++ {
+		key := []byte(strconv.Itoa(j))
+
+		switch {
+		case j >= 1000+delta/2:
+			insert(b1, s1, key)
+		case j >= 1000:
+			insert(b0, s0, key)
+		default:
+			insert(b0, s0, key)
+			insert(b1, s1, key)
+		}
+	}
+
+	initiator, responder = NewInitiator(b0, s0), NewResponder(b1, s1)
+	p = &pipe{}
+	p.run(t, initiator, responder)
+
+	return initiator, responder, p
+}
+
+func TestReconciler(t *testing.T) {
+	for _, tc := range []struct {
+		delta      int
+		estimators bool
+		size       uint64
+		messages   []MessageType
+	}{
+		{0, false, 20, []MessageType{MessageParams, MessageParams, MessageRequest, MessageIBF, MessageResult}},
+		{10, false, 20, []MessageType{MessageParams, MessageParams, MessageRequest, MessageIBF, MessageResult}},
+		{100, false, 200, []MessageType{
+			MessageParams, MessageParams,
+			MessageRequest, MessageIBF,
+			MessageEscalate, MessageIBF,
+			MessageEscalate, MessageIBF,
+			MessageResult,
+		}},
+		{100, true, 200, []MessageType{
+			MessageParams, MessageParams, MessageEstimator,
+			MessageRequest, MessageIBF,
+			MessageResult,
+		}},
+		{300, true, 600, nil},
+	} {
+		tc := tc
+
+		t.Run(strconv.Itoa(tc.delta)+"/"+strconv.FormatBool(tc.estimators), func(t *testing.T) {
+			initiator, responder, p := simulate(t, tc.delta, tc.estimators)
+
+			require.True(t, initiator.Done())
+			require.True(t, responder.Done())
+
+			if tc.messages != nil {
+				require.Equal(t, tc.messages, p.messages)
+			}
+
+			diff, size, err := initiator.Result()
+			require.NoError(t, err)
+			require.Equal(t, tc.size, size)
+			require.Len(t, diff.Left, tc.delta/2)
+			require.Len(t, diff.Right, tc.delta-tc.delta/2)
+
+			other, otherSize, err := responder.Result()
+			require.NoError(t, err)
+			require.Equal(t, size, otherSize)
+			require.Equal(t, diff.Left, other.Right)
+			require.Equal(t, diff.Right, other.Left)
+			require.Equal(t, negateCounts(diff.LeftCounts), other.RightCounts)
+		})
+	}
+
+	t.Run("too large", func(t *testing.T) {
+		initiator, responder, p := simulate(t, 2000, false)
+
+		_, _, err := initiator.Result()
+		require.Equal(t, ErrTooLarge, err)
+
+		_, _, err = responder.Result()
+		require.True(t, ErrPeer.Has(err))
+
+		require.Equal(t, MessageFail, p.messages[len(p.messages)-1])
+	})
+
+	t.Run("incompatible", func(t *testing.T) {
+		b0, err := NewBundle([]uint64{20, 60}, 1, DefaultOptions)
+		require.NoError(t, err)
+
+		b1, err := NewBundle([]uint64{20, 60}, 2, DefaultOptions)
+		require.NoError(t, err)
+
+		initiator, responder := NewInitiator(b0, nil), NewResponder(b1, nil)
+		(&pipe{}).run(t, initiator, responder)
+
+		_, _, err = initiator.Result()
+		require.Error(t, err)
+
+		_, _, err = responder.Result()
+		require.True(t, ErrPeer.Has(err))
+
+		// Messages after the end are rejected.
+		_, err = initiator.Handle(&Message{Type: MessageParams})
+		require.Error(t, err)
+	})
+
+	t.Run("unexpected", func(t *testing.T) {
+		b0, err := NewBundle([]uint64{20}, 1, DefaultOptions)
+		require.NoError(t, err)
+
+		responder := NewResponder(b0, nil)
+
+		replies, err := responder.Handle(&Message{Type: MessageRequest, Size: 20})
+		require.Error(t, err)
+		require.Equal(t, MessageFail, replies[len(replies)-1].Type)
+		require.True(t, responder.Done())
+	})
+
+	t.Run("encoding", func(t *testing.T) {
+		set := NewIBF(20, 1)
+		require.NoError(t, set.Insert([]byte("a")))
+
+		for _, m := range []*Message{
+			{Type: MessageParams, Sizes: []uint64{20, 60}, Fingerprints: []uint64{1, 2}, HasEstimator: true},
+			{Type: MessageEstimator, Estimator: NewStrata(1)},
+			{Type: MessageRequest, Size: 20},
+			{Type: MessageIBF, Size: 20, IBF: set},
+			{Type: MessageEscalate, Size: 60},
+			{Type: MessageResult, Size: 20, Difference: &Difference{
+				Left:        [][]byte{[]byte("a")},
+				LeftCounts:  []int64{1},
+				Right:       [][]byte{[]byte("b"), []byte("c")},
+				RightCounts: []int64{-1, -2},
+			}},
+			{Type: MessageFail, Reason: "no"},
+		} {
+			data, err := m.MarshalBinary()
+			require.NoError(t, err)
+
+			u := &Message{}
+			require.NoError(t, u.UnmarshalBinary(data))
+			require.Equal(t, m, u, m.Type.String())
+
+			for n := range data {
+				require.Error(t, (&Message{}).UnmarshalBinary(data[:n]), "%s truncated to %d", m.Type, n)
+			}
+		}
+
+		_, err := (&Message{Type: 99}).MarshalBinary()
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,236 @@
+package ibf
+
+import (
+	"encoding/json"
+	"math/bits"
+	"math/rand"
+)
+
+// Default strata estimator parameters. These are the values recommended in
+// the paper and are enough to estimate differences in the millions.
+const (
+	DefaultStrataCount = 32
+	DefaultStrataSize  = 80
+)
+
+// Strata holds the state of a strata estimator. Each key is assigned to
+// exactly one stratum by counting the trailing zeros of its hash, so stratum j
+// holds a sample of roughly 1/2^(j+1) of the set. Comparing the strata of two
+// sets estimates the size of their difference.
+type Strata struct {
+	Partitioner *Hash  `json:"partitioner"`
+	Strata      []*IBF `json:"strata"`
+}
+
+// NewStrata creates a new strata estimator with the default number of strata
+// and stratum size. The partitioner and the hash parameters of the strata are
+// created using the output from a random number generator initialized with
+// the seed.
+func NewStrata(seed int64) *Strata {
+	return NewStrataWithSize(DefaultStrataCount, DefaultStrataSize, seed)
+}
+
+// NewStrataWithSize creates a new strata estimator with count strata each
+// holding an IBF of the given size.
+func NewStrataWithSize(count int, size uint64, seed int64) *Strata {
+	rng := rand.New(rand.NewSource(seed))
+
+	// NOTE: The strata must not share hash parameters with the
+	// partitioner. Otherwise the keys in the deeper strata would all have
+	// positions with the same low bits and crowd into a few cells.
+	partitioner := NewHash(uint64(rng.Int63()), uint64(rng.Int63()))
+	ibfSeed := rng.Int63()
+
+	strata := make([]*IBF, count)
+	for j := range strata {
+		strata[j] = NewIBF(size, ibfSeed)
+	}
+
+	return &Strata{
+		Partitioner: partitioner,
+		Strata:      strata,
+	}
+}
+
+// getStratum returns the stratum the key belongs to.
+func (s *Strata) getStratum(key []byte) *IBF {
+	j := bits.TrailingZeros64(s.Partitioner.Hash(key))
+	if j >= len(s.Strata) {
+		j = len(s.Strata) - 1
+	}
+
+	return s.Strata[j]
+}
+
+// Insert adds the key to the estimator.
+func (s *Strata) Insert(key []byte) error {
+	return s.getStratum(key).Insert(key)
+}
+
+// Remove deletes the key from the estimator.
+func (s *Strata) Remove(key []byte) error {
+	return s.getStratum(key).Remove(key)
+}
+
+// Estimate returns the estimated size of the symmetric difference between
+// this set and the other set. Starting from the sparsest stratum, the
+// differences of each stratum are decoded until one fails. The number of
+// elements found so far is then scaled up by the sampling rate of the failed
+// stratum. If even the sparsest stratum cannot be decoded it returns
+// ErrNoPureCell.
+//
+// If the two estimators were not created with the same parameters it returns
+// an ErrIncompatible error.
+func (s *Strata) Estimate(other *Strata) (estimate uint64, err error) {
+	err = s.Compatible(other)
+	if err != nil {
+		return 0, err
+	}
+
+	var count uint64
+
+	for j := len(s.Strata) - 1; j >= 0; j-- {
+		set := s.Strata[j].Clone()
+
+		err = set.Subtract(other.Strata[j])
+		if err != nil {
+			return 0, err
+		}
+
+		diff, err := set.Decode()
+		if err != nil {
+			if count == 0 {
+				return 0, err
+			}
+
+			return count << uint(j+1), nil
+		}
+
+		count += uint64(len(diff.Left) + len(diff.Right))
+	}
+
+	return count, nil
+}
+
+// Compatible returns an ErrIncompatible error naming the first parameter that
+// differs between the two estimators.
+func (s *Strata) Compatible(other *Strata) error {
+	if len(s.Strata) != len(other.Strata) {
+		return ErrIncompatible.New("strata count %d != %d", len(s.Strata), len(other.Strata))
+	}
+
+	if s.Partitioner.Key != other.Partitioner.Key {
+		return ErrIncompatible.New("partitioner key %v != %v", s.Partitioner.Key, other.Partitioner.Key)
+	}
+
+	for j := range s.Strata {
+		err := s.Strata[j].Compatible(other.Strata[j])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MarshalBinary encodes the estimator in the compact binary format.
+func (s *Strata) MarshalBinary() (data []byte, err error) {
+	e := newEncoder(kindStrata)
+
+	e.hash(s.Partitioner)
+	e.uvarint(uint64(len(s.Strata)))
+
+	for _, stratum := range s.Strata {
+		data, err := stratum.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+
+		e.bytes(data)
+	}
+
+	return e.buf, nil
+}
+
+// UnmarshalBinary decodes an estimator encoded by MarshalBinary.
+func (s *Strata) UnmarshalBinary(data []byte) (err error) {
+	kind, data, err := decodeHeader(data)
+	if err != nil {
+		return err
+	}
+
+	if kind != kindStrata {
+		return Error.New("not a strata estimator")
+	}
+
+	d := &decoder{data: data}
+
+	partitioner := d.hash()
+	count := d.uvarint()
+
+	if d.err != nil {
+		return d.err
+	}
+
+	if count == 0 || count > uint64(len(d.data)) {
+		return Error.New("invalid strata count %d", count)
+	}
+
+	strata := make([]*IBF, count)
+	for j := range strata {
+		strata[j] = &IBF{}
+
+		err = strata[j].UnmarshalBinary(d.bytes())
+		if d.err != nil {
+			return d.err
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err = d.done()
+	if err != nil {
+		return err
+	}
+
+	*s = Strata{
+		Partitioner: partitioner,
+		Strata:      strata,
+	}
+
+	return nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *Strata) UnmarshalJSON(data []byte) (err error) {
+	// NOTE: The alias keeps json.Unmarshal from calling this method again.
+	type jsonStrata Strata
+
+	var v jsonStrata
+
+	err = json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+
+	// NOTE: Estimators are checked like the binary ones, so that one
+	// received from a peer can't make Estimate dereference nil.
+	if v.Partitioner == nil {
+		return Error.New("missing partitioner")
+	}
+
+	if len(v.Strata) == 0 {
+		return Error.New("invalid strata count 0")
+	}
+
+	for j, stratum := range v.Strata {
+		if stratum == nil {
+			return Error.New("missing stratum %d", j)
+		}
+	}
+
+	*s = Strata(v)
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package ibf
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStrata(t *testing.T) {
+	t.Run("identical", func(t *testing.T) {
+		s0 := NewStrata(1)
+		s1 := NewStrata(1)
+
+		for j := 0; j < 1000; j++ {
+			s0.Insert([]byte(strconv.Itoa(j)))
+			s1.Insert([]byte(strconv.Itoa(j)))
+		}
+
+		estimate, err := s0.Estimate(s1)
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), estimate)
+	})
+
+	for _, delta := range []int{10, 100, 1000, 10000} {
+		delta := delta
+
+		t.Run(fmt.Sprintf("delta %d", delta), func(t *testing.T) {
+			s0 := NewStrata(2)
+			s1 := NewStrata(2)
+
+			// Shared elements.
+			for j := 0; j < 10000; j++ {
+				s0.Insert([]byte(strconv.Itoa(j)))
+				s1.Insert([]byte(strconv.Itoa(j)))
+			}
+
+			// Half the difference on each side.
+			for j := 0; j < delta; j++ {
+				key := []byte(strconv.Itoa(-j - 1))
+
+				if j%2 == 0 {
+					s0.Insert(key)
+				} else {
+					s1.Insert(key)
+				}
+			}
+
+			estimate, err := s0.Estimate(s1)
+			require.NoError(t, err)
+			t.Log("estimate:", estimate)
+
+			require.True(t, estimate >= uint64(delta)/2, "estimate %d too small for %d", estimate, delta)
+			require.True(t, estimate <= uint64(delta)*2, "estimate %d too large for %d", estimate, delta)
+
+			// The estimate is symmetric.
+			reverse, err := s1.Estimate(s0)
+			require.NoError(t, err)
+			require.Equal(t, estimate, reverse)
+		})
+	}
+
+	t.Run("incompatible", func(t *testing.T) {
+		_, err := NewStrata(1).Estimate(NewStrata(2))
+		require.True(t, ErrIncompatible.Has(err), "%+v", err)
+
+		_, err = NewStrata(1).Estimate(NewStrataWithSize(DefaultStrataCount, DefaultStrataSize+1, 1))
+		require.True(t, ErrIncompatible.Has(err), "%+v", err)
+	})
+}
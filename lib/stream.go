@@ -0,0 +1,194 @@
+package ibf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// A symbol stream holds coded symbols produced by an Encoder. It starts with
+// the binary encoding header and a parameter section naming the hasher and
+// the index of the first symbol. Each symbol follows as a length prefixed
+// record so that the stream can be read while it is still being written.
+
+// maxSymbolSize is the largest encoded symbol a SymbolReader accepts.
+const maxSymbolSize = 1 << 30
+
+// SymbolWriter writes a symbol stream.
+type SymbolWriter struct {
+	w io.Writer
+}
+
+// NewSymbolWriter writes the header of a stream whose symbols are produced by
+// an encoder using the hasher, starting with the symbol at the index.
+func NewSymbolWriter(w io.Writer, hasher Hasher, start uint64) (*SymbolWriter, error) {
+	e := newEncoder(kindSymbols)
+
+	e.params(func(e *encoder) {
+		e.param(tagHasher, func(e *encoder) {
+			e.hash(hasher)
+		})
+
+		if hasher.Name() != DefaultHasher {
+			e.param(tagHash, func(e *encoder) {
+				e.bytes([]byte(hasher.Name()))
+			})
+		}
+
+		if start > 0 {
+			e.param(tagStart, func(e *encoder) {
+				e.uvarint(start)
+			})
+		}
+	})
+
+	_, err := w.Write(e.buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SymbolWriter{w: w}, nil
+}
+
+// Write appends the symbol to the stream.
+func (sw *SymbolWriter) Write(symbol *Cell) error {
+	record := &encoder{}
+	record.varint(symbol.Count)
+	record.uint64(symbol.Digest)
+	record.bytes(symbol.Key.Data)
+
+	e := &encoder{}
+	e.bytes(record.buf)
+
+	_, err := sw.w.Write(e.buf)
+
+	return err
+}
+
+// SymbolReader reads a symbol stream.
+type SymbolReader struct {
+	// Hasher is the hasher used by the encoder of the stream.
+	Hasher Hasher
+
+	// Start is the index of the first symbol in the stream.
+	Start uint64
+
+	r *bufio.Reader
+}
+
+// NewSymbolReader reads the header of a symbol stream.
+func NewSymbolReader(r io.Reader) (*SymbolReader, error) {
+	br := bufio.NewReader(r)
+
+	header := make([]byte, len(magic)+2)
+
+	_, err := io.ReadFull(br, header)
+	if err != nil {
+		return nil, Error.New("truncated header")
+	}
+
+	kind, _, err := decodeHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	if kind != kindSymbols {
+		return nil, Error.New("not a symbol stream")
+	}
+
+	section, err := readRecord(br)
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: The section was read with its length, which the decoder
+	// expects to find in front of it.
+	e := &encoder{}
+	e.bytes(section)
+
+	d := &decoder{data: e.buf}
+
+	var hasherKey *[2]uint64
+	var name = DefaultHasher
+	var start uint64
+
+	d.params(func(tag uint64, v *decoder) {
+		switch tag {
+		case tagHasher:
+			key := v.key()
+			hasherKey = &key
+		case tagHash:
+			name = string(v.bytes())
+		case tagStart:
+			start = v.uvarint()
+		default:
+			v.fail(Error.New("unknown parameter %d", tag))
+		}
+	})
+
+	err = d.done()
+	if err != nil {
+		return nil, err
+	}
+
+	if hasherKey == nil {
+		return nil, Error.New("missing hasher")
+	}
+
+	hasher, err := NewHasher(name, hasherKey[0], hasherKey[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return &SymbolReader{
+		Hasher: hasher,
+		Start:  start,
+		r:      br,
+	}, nil
+}
+
+// Read returns the next symbol in the stream. It returns io.EOF at the end of
+// the stream.
+func (sr *SymbolReader) Read() (*Cell, error) {
+	// NOTE: A clean end of stream is only allowed between symbols.
+	_, err := sr.r.Peek(1)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+
+	record, err := readRecord(sr.r)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &decoder{data: record}
+	symbol := decodeCell(d, false)
+
+	err = d.done()
+	if err != nil {
+		return nil, err
+	}
+
+	return symbol, nil
+}
+
+// readRecord reads length prefixed data.
+func readRecord(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, Error.New("truncated stream")
+	}
+
+	if size > maxSymbolSize {
+		return nil, Error.New("symbol too large: %d", size)
+	}
+
+	data := make([]byte, size)
+
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return nil, Error.New("truncated stream")
+	}
+
+	return data, nil
+}
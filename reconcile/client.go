@@ -0,0 +1,260 @@
+package reconcile
+
+import (
+	"io"
+	"net"
+
+	ibf "github.com/calebcase/ibf/lib"
+	"github.com/zeebo/errs"
+)
+
+// Client is a session with a server for one of its sets.
+type Client struct {
+	c      *conn
+	remote *ibf.Message
+	closer io.Closer
+
+	// fetching is true while a reconciliation started to fetch the remote
+	// set is running, and strata holds the estimator it received.
+	fetching bool
+	strata   *ibf.Strata
+}
+
+// NewClient starts a session over rw for the set with the name. An empty name
+// selects the set of a server that only has one.
+func NewClient(rw io.ReadWriter, name string) (*Client, error) {
+	c := newConn(rw)
+
+	err := c.send(frameHello, encodeHello(name))
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	remote, err := c.expectMessage(ibf.MessageParams)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		c:      c,
+		remote: remote,
+	}, nil
+}
+
+// Dial connects to the server at the address, given as unix:PATH or
+// tcp:HOST:PORT, and starts a session for the set with the name.
+func Dial(addr, name string) (*Client, error) {
+	network, address, err := ParseAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	nc, err := net.Dial(network, address)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	client, err := NewClient(nc, name)
+	if err != nil {
+		return nil, errs.Combine(err, nc.Close())
+	}
+
+	client.closer = nc
+
+	return client, nil
+}
+
+// Close closes the connection of a client created by Dial.
+func (cl *Client) Close() error {
+	if cl.closer == nil {
+		return nil
+	}
+
+	return Error.Wrap(cl.closer.Close())
+}
+
+// GetSizes returns the sizes the remote set is available at from smallest to
+// largest.
+func (cl *Client) GetSizes() []uint64 {
+	return cl.remote.Sizes
+}
+
+// GetFingerprint returns the fingerprint of the parameters of the remote set
+// at the size. It returns false if the set isn't available at the size.
+func (cl *Client) GetFingerprint(size uint64) (uint64, bool) {
+	for j, s := range cl.remote.Sizes {
+		if s == size && j < len(cl.remote.Fingerprints) {
+			return cl.remote.Fingerprints[j], true
+		}
+	}
+
+	return 0, false
+}
+
+// HasStrata returns true if the remote set has an estimator.
+func (cl *Client) HasStrata() bool {
+	return cl.remote.HasEstimator
+}
+
+// startFetch starts a reconciliation in which the client only fetches the
+// remote set. It mirrors the parameters of the server, so that all of its
+// sizes are common, and receives its estimator if it has one.
+func (cl *Client) startFetch() error {
+	if cl.fetching {
+		return nil
+	}
+
+	err := cl.c.sendMessage(&ibf.Message{
+		Type:         ibf.MessageParams,
+		Sizes:        cl.remote.Sizes,
+		Fingerprints: cl.remote.Fingerprints,
+		HasEstimator: cl.remote.HasEstimator,
+	})
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	_, err = cl.c.expectMessage(ibf.MessageParams)
+	if err != nil {
+		return err
+	}
+
+	if cl.remote.HasEstimator {
+		m, err := cl.c.expectMessage(ibf.MessageEstimator)
+		if err != nil {
+			return err
+		}
+
+		cl.strata = m.Estimator
+	}
+
+	cl.fetching = true
+
+	return nil
+}
+
+// Fetch returns the remote set at the size.
+func (cl *Client) Fetch(size uint64) (*ibf.IBF, error) {
+	err := cl.startFetch()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cl.c.sendMessage(&ibf.Message{Type: ibf.MessageRequest, Size: size})
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	m, err := cl.c.expectMessage(ibf.MessageIBF)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Size != size {
+		return nil, Error.New("unexpected IBF of size %d", m.Size)
+	}
+
+	return m.IBF, nil
+}
+
+// FetchStrata returns the estimator of the remote set.
+func (cl *Client) FetchStrata() (*ibf.Strata, error) {
+	if !cl.remote.HasEstimator {
+		return nil, Error.New("no estimator")
+	}
+
+	err := cl.startFetch()
+	if err != nil {
+		return nil, err
+	}
+
+	return cl.strata, nil
+}
+
+// Sync reconciles the local set with the remote set by running an
+// ibf.Reconciler initiator against the server. The server is sent the result
+// so that both sides end up knowing the difference. Unless the elements are
+// transferred the session is over.
+func (cl *Client) Sync(local *Set) (*Result, error) {
+	cl.fetching = false
+
+	r := ibf.NewInitiator(local, local.Strata)
+
+	for _, m := range r.Start() {
+		err := cl.c.sendMessage(m)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+	}
+
+	for !r.Done() {
+		m, err := cl.c.recvMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		replies, err := r.Handle(m)
+
+		for _, reply := range replies {
+			sendErr := cl.c.sendMessage(reply)
+			if sendErr != nil {
+				return nil, Error.Wrap(sendErr)
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	diff, size, err := r.Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		Difference: diff,
+		Size:       size,
+	}, nil
+}
+
+// Transfer reconciles the local set with the remote set like Sync and then
+// exchanges the elements each side lacks before ending the session. The
+// elements received are returned in the result once checked to be exactly
+// those of the keys only the server has. Servers only exchange elements for
+// sets with Resolve.
+func (cl *Client) Transfer(local *Set) (*Result, error) {
+	res, err := cl.Sync(local)
+	if err != nil {
+		return nil, err
+	}
+
+	elements, err := local.resolve(res.Difference.Left)
+	if err != nil {
+		return nil, cl.c.fail(err)
+	}
+
+	err = cl.c.send(frameElements, encodeElements(elements))
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	payload, err := cl.c.expect(frameElements)
+	if err != nil {
+		return nil, err
+	}
+
+	received, err := decodeElements(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	err = local.check(received, res.Difference.Right)
+	if err != nil {
+		return nil, err
+	}
+
+	res.Received = received
+
+	return res, nil
+}
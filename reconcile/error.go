@@ -0,0 +1,18 @@
+package reconcile
+
+import (
+	ibf "github.com/calebcase/ibf/lib"
+	"github.com/zeebo/errs"
+)
+
+// Errors for this package.
+var (
+	Error = errs.Class("reconcile")
+
+	// ErrTooLarge is returned when the difference could not be decoded
+	// with the largest size both peers have.
+	ErrTooLarge = ibf.ErrTooLarge
+
+	// ErrPeer is the class of errors reported by the peer.
+	ErrPeer = ibf.ErrPeer
+)
@@ -0,0 +1,184 @@
+package http
+
+import (
+	"encoding"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	ibf "github.com/calebcase/ibf/lib"
+	"github.com/calebcase/ibf/reconcile"
+	"github.com/zeebo/errs"
+)
+
+// Error is the class of errors returned by this package.
+var Error = errs.Class("reconcile http")
+
+// maxResponseSize is the largest response body a client reads.
+const maxResponseSize = 1 << 30
+
+// Client fetches a set served by a Handler. It implements reconcile.Peer.
+type Client struct {
+	// URL is where the handler is mounted.
+	URL string
+
+	// HTTPClient sends the requests.
+	HTTPClient *http.Client
+
+	// JSON requests the JSON encoding instead of the binary one.
+	JSON bool
+
+	params Params
+}
+
+// NewClient returns a client for the handler mounted at the URL. It fetches
+// the parameters of the set. If httpClient is nil http.DefaultClient is used.
+func NewClient(rawurl string, httpClient *http.Client) (*Client, error) {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	c := &Client{
+		URL:        strings.TrimSuffix(rawurl, "/"),
+		HTTPClient: httpClient,
+	}
+
+	err := c.get("params", nil, ContentTypeJSON, func(contentType string, data []byte) error {
+		return json.Unmarshal(data, &c.params)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// get requests the path below the URL, accepting the media type, and passes
+// the response to decode.
+func (c *Client) get(path string, query url.Values, accept string, decode func(contentType string, data []byte) error) error {
+	target := c.URL + "/" + path
+	if query != nil {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	req.Header.Set("Accept", accept)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	if len(data) > maxResponseSize {
+		return Error.New("%s: response too large", target)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return Error.New("%s: %s: %s", target, resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	contentType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	err = decode(contentType, data)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	return nil
+}
+
+// fetch requests the path and decodes the response into v.
+func (c *Client) fetch(path string, query url.Values, v encoding.BinaryUnmarshaler) error {
+	accept := ContentTypeBinary
+	if c.JSON {
+		accept = ContentTypeJSON
+	}
+
+	return c.get(path, query, accept, func(contentType string, data []byte) error {
+		switch contentType {
+		case ContentTypeBinary:
+			return v.UnmarshalBinary(data)
+		case ContentTypeJSON:
+			return json.Unmarshal(data, v)
+		}
+
+		return Error.New("unsupported content type %q", contentType)
+	})
+}
+
+// GetParams returns the parameters of the set.
+func (c *Client) GetParams() Params {
+	return c.params
+}
+
+// GetSizes returns the sizes the set is available at from smallest to
+// largest.
+func (c *Client) GetSizes() []uint64 {
+	return c.params.Sizes
+}
+
+// GetFingerprint returns the fingerprint of the parameters of the set at the
+// size. It returns false if the set isn't available at the size.
+func (c *Client) GetFingerprint(size uint64) (uint64, bool) {
+	for j, s := range c.params.Sizes {
+		if s == size && j < len(c.params.Fingerprints) {
+			return c.params.Fingerprints[j], true
+		}
+	}
+
+	return 0, false
+}
+
+// HasStrata returns true if the set has an estimator.
+func (c *Client) HasStrata() bool {
+	return c.params.Strata
+}
+
+// Fetch returns the set at the size.
+func (c *Client) Fetch(size uint64) (*ibf.IBF, error) {
+	set := &ibf.IBF{}
+
+	err := c.fetch("ibf", url.Values{"size": {strconv.FormatUint(size, 10)}}, set)
+	if err != nil {
+		return nil, err
+	}
+
+	return set, nil
+}
+
+// FetchStrata returns the estimator of the set.
+func (c *Client) FetchStrata() (*ibf.Strata, error) {
+	strata := &ibf.Strata{}
+
+	err := c.fetch("strata", nil, strata)
+	if err != nil {
+		return nil, err
+	}
+
+	return strata, nil
+}
+
+// Diff computes the difference between the local set and the served set.
+// Left holds the elements only the local set has and Right the elements only
+// the served set has. See reconcile.Diff.
+func (c *Client) Diff(local *reconcile.Set) (*reconcile.Result, error) {
+	return reconcile.Diff(local, c)
+}
@@ -0,0 +1,214 @@
+// Package http serves sets for reconciliation over HTTP and computes the
+// difference with a set served this way.
+//
+// A handler answers GET requests relative to where it is mounted:
+//
+//	params            the sizes, fingerprints and whether there is an estimator
+//	ibf?size=N        the set at size N
+//	strata            the estimator
+//
+// IBFs and estimators are sent in the library's binary format unless the
+// request prefers JSON in its Accept header.
+package http
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/calebcase/ibf/reconcile"
+)
+
+// Media types used for the set and its estimator.
+const (
+	ContentTypeBinary = "application/octet-stream"
+	ContentTypeJSON   = "application/json"
+)
+
+// Params describes the sizes a set is available at.
+type Params struct {
+	// Sizes holds the sizes from smallest to largest.
+	Sizes []uint64 `json:"sizes"`
+
+	// Fingerprints holds the fingerprint of the parameters of the set at
+	// each size.
+	Fingerprints []uint64 `json:"fingerprints"`
+
+	// Strata is true if the set has an estimator.
+	Strata bool `json:"strata"`
+}
+
+// Handler serves a set.
+//
+// NOTE: Requests only read the set, so it must not be modified while the
+// handler is in use.
+type Handler struct {
+	Set *reconcile.Set
+}
+
+// NewHandler returns a handler serving the set.
+func NewHandler(set *reconcile.Set) *Handler {
+	return &Handler{Set: set}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	switch r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:] {
+	case "params":
+		h.serveParams(w, r)
+	case "ibf":
+		h.serveIBF(w, r)
+	case "strata":
+		h.serveStrata(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) serveParams(w http.ResponseWriter, r *http.Request) {
+	params := Params{
+		Sizes:  h.Set.GetSizes(),
+		Strata: h.Set.Strata != nil,
+	}
+
+	for _, size := range params.Sizes {
+		params.Fingerprints = append(params.Fingerprints, h.Set.GetFingerprint(size))
+	}
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	_, _ = w.Write(data)
+}
+
+func (h *Handler) serveIBF(w http.ResponseWriter, r *http.Request) {
+	size, err := strconv.ParseUint(r.URL.Query().Get("size"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid size", http.StatusBadRequest)
+
+		return
+	}
+
+	set, err := h.Set.GetIBF(size)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	write(w, r, set)
+}
+
+func (h *Handler) serveStrata(w http.ResponseWriter, r *http.Request) {
+	if h.Set.Strata == nil {
+		http.Error(w, "no estimator", http.StatusNotFound)
+
+		return
+	}
+
+	write(w, r, h.Set.Strata)
+}
+
+// write writes v in the format negotiated with the request.
+func write(w http.ResponseWriter, r *http.Request, v interface{}) {
+	contentType := negotiate(r.Header.Get("Accept"))
+	if contentType == "" {
+		http.Error(w, "supported types are "+ContentTypeBinary+" and "+ContentTypeJSON, http.StatusNotAcceptable)
+
+		return
+	}
+
+	var data []byte
+	var err error
+
+	if contentType == ContentTypeJSON {
+		data, err = json.Marshal(v)
+	} else {
+		data, err = v.(encoding.BinaryMarshaler).MarshalBinary()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Vary", "Accept")
+	_, _ = w.Write(data)
+}
+
+// negotiate returns the supported media type preferred by the Accept header,
+// the binary format if there is no preference, or an empty string if neither
+// is acceptable.
+func negotiate(accept string) string {
+	if strings.TrimSpace(accept) == "" {
+		return ContentTypeBinary
+	}
+
+	qBinary, qJSON := quality(accept, ContentTypeBinary), quality(accept, ContentTypeJSON)
+
+	switch {
+	case qJSON > qBinary:
+		return ContentTypeJSON
+	case qBinary > 0:
+		return ContentTypeBinary
+	}
+
+	return ""
+}
+
+// quality returns the quality the Accept header gives to the media type. The
+// most specific matching range applies.
+func quality(accept, mediaType string) float64 {
+	q, specificity := 0.0, -1
+
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaRange := strings.ToLower(strings.TrimSpace(fields[0]))
+
+		var s int
+
+		switch mediaRange {
+		case mediaType:
+			s = 2
+		case mediaType[:strings.Index(mediaType, "/")] + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		default:
+			continue
+		}
+
+		if s <= specificity {
+			continue
+		}
+
+		specificity, q = s, 1.0
+
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				parsed, err := strconv.ParseFloat(param[2:], 64)
+				if err == nil {
+					q = parsed
+				}
+			}
+		}
+	}
+
+	return q
+}
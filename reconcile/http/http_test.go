@@ -0,0 +1,135 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	ibf "github.com/calebcase/ibf/lib"
+	"github.com/calebcase/ibf/reconcile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	b0, err := ibf.NewBundle([]uint64{20, 60, 200}, 1, ibf.DefaultOptions)
+	require.NoError(t, err)
+
+	b1 := b0.Clone()
+	s0, s1 := ibf.NewStrata(1), ibf.NewStrata(1)
+
+	for j := 0; j < 1000; j++ {
+		key := []byte(strconv.Itoa(j))
+
+		if j >= 10 {
+			require.NoError(t, b0.Insert(key))
+			require.NoError(t, s0.Insert(key))
+		}
+
+		if j < 990 {
+			require.NoError(t, b1.Insert(key))
+			require.NoError(t, s1.Insert(key))
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/sets/a/", http.StripPrefix("/sets/a", NewHandler(&reconcile.Set{Bundle: b0, Strata: s0})))
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	t.Run("diff", func(t *testing.T) {
+		for _, useJSON := range []bool{false, true} {
+			client, err := NewClient(server.URL+"/sets/a/", nil)
+			require.NoError(t, err)
+
+			client.JSON = useJSON
+
+			require.Equal(t, []uint64{20, 60, 200}, client.GetSizes())
+			require.True(t, client.HasStrata())
+
+			fingerprint, ok := client.GetFingerprint(60)
+			require.True(t, ok)
+			require.Equal(t, b0.Members[1].Fingerprint(), fingerprint)
+
+			res, err := client.Diff(&reconcile.Set{Bundle: b1, Strata: s1})
+			require.NoError(t, err)
+			require.Len(t, res.Difference.Left, 10)
+			require.Len(t, res.Difference.Right, 10)
+
+			set, err := client.Fetch(200)
+			require.NoError(t, err)
+			require.Equal(t, b0.Members[2], set)
+
+			strata, err := client.FetchStrata()
+			require.NoError(t, err)
+			require.Equal(t, s0, strata)
+
+			_, err = client.Fetch(100)
+			require.Error(t, err)
+		}
+	})
+
+	t.Run("invalid strata", func(t *testing.T) {
+		// NOTE: A peer sending an estimator without strata or a
+		// partitioner used to crash Diff.
+		for path, body := range map[string]string{
+			"/sets/empty/":       `{"partitioner": {"key": [1, 2]}, "strata": []}`,
+			"/sets/partitioner/": `{"strata": [null]}`,
+		} {
+			body := body
+
+			mux.Handle(path, http.StripPrefix(strings.TrimSuffix(path, "/"), NewHandler(&reconcile.Set{Bundle: b0, Strata: s0})))
+			mux.HandleFunc(path+"strata", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", ContentTypeJSON)
+				_, _ = w.Write([]byte(body))
+			})
+
+			client, err := NewClient(server.URL+path, nil)
+			require.NoError(t, err)
+
+			_, err = client.FetchStrata()
+			require.Error(t, err, path)
+
+			_, err = client.Diff(&reconcile.Set{Bundle: b1, Strata: s1})
+			require.Error(t, err, path)
+		}
+	})
+
+	t.Run("negotiation", func(t *testing.T) {
+		get := func(path, accept string) *http.Response {
+			req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+			require.NoError(t, err)
+
+			if accept != "" {
+				req.Header.Set("Accept", accept)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+
+			return resp
+		}
+
+		for accept, expected := range map[string]string{
+			"":                                      ContentTypeBinary,
+			"*/*":                                   ContentTypeBinary,
+			"application/json":                      ContentTypeJSON,
+			"application/octet-stream":              ContentTypeBinary,
+			"application/*;q=0.5, application/json": ContentTypeJSON,
+			"application/json;q=0.5, */*":           ContentTypeBinary,
+			"application/json, */*;q=0.1":           ContentTypeJSON,
+		} {
+			resp := get("/sets/a/ibf?size=20", accept)
+			require.Equal(t, http.StatusOK, resp.StatusCode, accept)
+			require.Equal(t, expected, resp.Header.Get("Content-Type"), accept)
+		}
+
+		require.Equal(t, http.StatusNotAcceptable, get("/sets/a/strata", "text/plain").StatusCode)
+		require.Equal(t, http.StatusNotAcceptable, get("/sets/a/strata", "application/json;q=0, application/octet-stream;q=0").StatusCode)
+		require.Equal(t, http.StatusBadRequest, get("/sets/a/ibf", "").StatusCode)
+		require.Equal(t, http.StatusNotFound, get("/sets/a/missing", "").StatusCode)
+	})
+}
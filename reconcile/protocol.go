@@ -0,0 +1,263 @@
+package reconcile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	ibf "github.com/calebcase/ibf/lib"
+)
+
+// The protocol is a sequence of frames sent over any byte stream. Each frame
+// is the uvarint length of its body followed by the body: a byte giving the
+// frame type and the payload.
+//
+// The client starts by sending a hello naming the set it wants. The server
+// answers with the parameters of that set, or a failure, as an ibf.Message.
+// From then on the reconciliation is run by an initiator on the client and a
+// responder on the server exchanging ibf.Message frames (see ibf.Reconciler).
+// Every parameters message sent by the client starts a new reconciliation, so
+// a client only fetching the set sends the parameters of the server back and
+// requests any of its sizes. The session ends once the result or a failure
+// is sent, or when the client closes the stream between frames. After the
+// result the client may send the elements the server lacks, which the server
+// answers with the elements the client lacks.
+
+// ProtocolVersion is the version of the protocol spoken by this package.
+const ProtocolVersion = 2
+
+// maxFrameSize is the largest frame accepted.
+const maxFrameSize = 1 << 30
+
+// Frame types.
+const (
+	frameHello byte = iota + 1
+	frameMessage
+	frameElements
+)
+
+// encodeHello encodes the hello of a client as the protocol version followed
+// by the length prefixed name of the set.
+func encodeHello(name string) []byte {
+	var scratch [binary.MaxVarintLen64]byte
+
+	n := binary.PutUvarint(scratch[:], ProtocolVersion)
+	data := append([]byte{}, scratch[:n]...)
+
+	n = binary.PutUvarint(scratch[:], uint64(len(name)))
+	data = append(data, scratch[:n]...)
+
+	return append(data, name...)
+}
+
+// decodeHello decodes a hello encoded by encodeHello.
+func decodeHello(data []byte) (version uint64, name string, err error) {
+	version, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, "", Error.New("invalid hello")
+	}
+
+	data = data[n:]
+
+	size, n := binary.Uvarint(data)
+	if n <= 0 || size != uint64(len(data)-n) {
+		return 0, "", Error.New("invalid hello")
+	}
+
+	return version, string(data[n:]), nil
+}
+
+// encodeElements encodes the elements as their count followed by each
+// element prefixed with its length.
+func encodeElements(elements [][]byte) []byte {
+	var scratch [binary.MaxVarintLen64]byte
+
+	n := binary.PutUvarint(scratch[:], uint64(len(elements)))
+	data := append([]byte{}, scratch[:n]...)
+
+	for _, element := range elements {
+		n = binary.PutUvarint(scratch[:], uint64(len(element)))
+		data = append(data, scratch[:n]...)
+		data = append(data, element...)
+	}
+
+	return data
+}
+
+// decodeElements decodes elements encoded by encodeElements.
+func decodeElements(data []byte) (elements [][]byte, err error) {
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data)) {
+		return nil, Error.New("invalid element count")
+	}
+
+	data = data[n:]
+
+	for j := uint64(0); j < count; j++ {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || size > uint64(len(data)-n) {
+			return nil, Error.New("truncated element")
+		}
+
+		elements = append(elements, data[n:n+int(size)])
+		data = data[n+int(size):]
+	}
+
+	if len(data) > 0 {
+		return nil, Error.New("trailing data")
+	}
+
+	return elements, nil
+}
+
+// conn reads and writes frames.
+type conn struct {
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func newConn(rw io.ReadWriter) *conn {
+	return &conn{
+		r: bufio.NewReader(rw),
+		w: bufio.NewWriter(rw),
+	}
+}
+
+// send writes a frame and flushes it.
+func (c *conn) send(kind byte, payload []byte) error {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(scratch[:], uint64(1+len(payload)))
+
+	_, err := c.w.Write(scratch[:n])
+	if err != nil {
+		return err
+	}
+
+	err = c.w.WriteByte(kind)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.w.Write(payload)
+	if err != nil {
+		return err
+	}
+
+	return c.w.Flush()
+}
+
+// sendMessage writes a frame with the message.
+func (c *conn) sendMessage(m *ibf.Message) error {
+	payload, err := m.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	return c.send(frameMessage, payload)
+}
+
+// fail tells the peer why the session ends and returns err.
+func (c *conn) fail(err error) error {
+	_ = c.sendMessage(&ibf.Message{Type: ibf.MessageFail, Reason: err.Error()})
+
+	return err
+}
+
+// recv reads the next frame. It returns io.EOF if the stream ends cleanly
+// before the frame.
+func (c *conn) recv() (kind byte, payload []byte, err error) {
+	size, err := binary.ReadUvarint(c.r)
+	if err == io.EOF {
+		return 0, nil, io.EOF
+	}
+	if err != nil {
+		return 0, nil, Error.New("reading frame: %v", err)
+	}
+
+	if size == 0 || size > maxFrameSize {
+		return 0, nil, Error.New("invalid frame size %d", size)
+	}
+
+	// NOTE: The buffer grows as the frame arrives rather than being
+	// allocated at the size the peer declared.
+	var body bytes.Buffer
+
+	_, err = io.CopyN(&body, c.r, int64(size))
+	if err != nil {
+		return 0, nil, Error.New("reading frame: %v", err)
+	}
+
+	return body.Bytes()[0], body.Bytes()[1:], nil
+}
+
+// expect reads the next frame and checks it has the frame type. A failure
+// sent by the peer is returned as an ErrPeer error.
+func (c *conn) expect(kind byte) (payload []byte, err error) {
+	got, payload, err := c.recv()
+	if err == io.EOF {
+		return nil, Error.New("peer closed the stream")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if got == frameMessage && kind != frameMessage {
+		m, err := decodeMessage(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		if m.Type == ibf.MessageFail {
+			return nil, ErrPeer.New("%s", m.Reason)
+		}
+	}
+
+	if got != kind {
+		return nil, Error.New("unexpected frame %d, expected %d", got, kind)
+	}
+
+	return payload, nil
+}
+
+// recvMessage reads the next frame, which must be a message. Failures are
+// returned as messages so that they can be passed to a reconciler.
+func (c *conn) recvMessage() (*ibf.Message, error) {
+	payload, err := c.expect(frameMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeMessage(payload)
+}
+
+// expectMessage reads the next message and checks it has the type. A failure
+// sent by the peer is returned as an ErrPeer error.
+func (c *conn) expectMessage(t ibf.MessageType) (*ibf.Message, error) {
+	m, err := c.recvMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Type == ibf.MessageFail {
+		return nil, ErrPeer.New("%s", m.Reason)
+	}
+
+	if m.Type != t {
+		return nil, Error.New("unexpected %s message, expected %s", m.Type, t)
+	}
+
+	return m, nil
+}
+
+// decodeMessage decodes the payload of a message frame.
+func decodeMessage(payload []byte) (*ibf.Message, error) {
+	m := &ibf.Message{}
+
+	err := m.UnmarshalBinary(payload)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,315 @@
+package reconcile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"runtime"
+	"sort"
+	"strconv"
+	"testing"
+
+	ibf "github.com/calebcase/ibf/lib"
+	"github.com/stretchr/testify/require"
+)
+
+// run reconciles the two sets over an in-memory connection.
+func run(t *testing.T, local, remote *Set) (synced, served *Result, syncErr, serveErr error) {
+	a, b := net.Pipe()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		defer func() { _ = b.Close() }()
+
+		served, serveErr = Serve(b, remote)
+	}()
+
+	synced, syncErr = Sync(a, local)
+	_ = a.Close()
+
+	<-done
+
+	return synced, served, syncErr, serveErr
+}
+
+func strs(keys [][]byte) (s []string) {
+	for _, key := range keys {
+		s = append(s, string(key))
+	}
+
+	sort.Strings(s)
+
+	return s
+}
+
+// fill inserts the keys from start up to end.
+func fill(t *testing.T, insert func([]byte) error, start, end int) {
+	for j := start; j < end; j++ {
+		require.NoError(t, insert([]byte(strconv.Itoa(j))))
+	}
+}
+
+func TestSync(t *testing.T) {
+	t.Run("bundle", func(t *testing.T) {
+		b0, err := ibf.NewBundle([]uint64{20, 60, 200, 600}, 1, ibf.DefaultOptions)
+		require.NoError(t, err)
+
+		b1 := b0.Clone()
+
+		fill(t, b0.Insert, 0, 1000)
+		fill(t, b1.Insert, 50, 1100)
+
+		local, err := NewSet(b0)
+		require.NoError(t, err)
+
+		remote, err := NewSet(b1)
+		require.NoError(t, err)
+
+		synced, served, syncErr, serveErr := run(t, local, remote)
+		require.NoError(t, syncErr)
+		require.NoError(t, serveErr)
+
+		require.Equal(t, uint64(200), synced.Size)
+		require.Len(t, synced.Difference.Left, 50)
+		require.Len(t, synced.Difference.Right, 100)
+
+		require.Equal(t, synced.Size, served.Size)
+		require.Equal(t, strs(synced.Difference.Left), strs(served.Difference.Right))
+		require.Equal(t, strs(synced.Difference.Right), strs(served.Difference.Left))
+		require.Equal(t, []int64{1}, served.Difference.LeftCounts[:1])
+		require.Equal(t, []int64{-1}, served.Difference.RightCounts[:1])
+	})
+
+	t.Run("strata", func(t *testing.T) {
+		b0, err := ibf.NewBundle([]uint64{20, 60, 200, 600}, 1, ibf.DefaultOptions)
+		require.NoError(t, err)
+
+		b1 := b0.Clone()
+		s0, s1 := ibf.NewStrata(1), ibf.NewStrata(1)
+
+		fill(t, b0.Insert, 0, 1000)
+		fill(t, s0.Insert, 0, 1000)
+		fill(t, b1.Insert, 10, 1000)
+		fill(t, s1.Insert, 10, 1000)
+
+		local := &Set{Bundle: b0, Strata: s0}
+		remote := &Set{Bundle: b1, Strata: s1}
+
+		synced, served, syncErr, serveErr := run(t, local, remote)
+		require.NoError(t, syncErr)
+		require.NoError(t, serveErr)
+		require.Len(t, synced.Difference.Left, 10)
+		require.Len(t, served.Difference.Right, 10)
+
+		// Without the remote estimator the smallest size is tried
+		// first.
+		remote.Strata = nil
+
+		synced, _, syncErr, serveErr = run(t, local, remote)
+		require.NoError(t, syncErr)
+		require.NoError(t, serveErr)
+		require.Equal(t, uint64(20), synced.Size)
+	})
+
+	t.Run("fold", func(t *testing.T) {
+		opts := ibf.DefaultOptions
+		opts.Placement = ibf.PlacementModulo
+
+		i0, err := ibf.NewIBFWithOptions(1024, 1, opts)
+		require.NoError(t, err)
+
+		i1 := i0.Clone()
+
+		fill(t, i0.Insert, 0, 1000)
+		fill(t, i1.Insert, 20, 1000)
+
+		local, err := NewSet(i0)
+		require.NoError(t, err)
+		require.Equal(t, []uint64{32, 64, 128, 256, 512, 1024}, local.GetSizes())
+
+		remote, err := NewSet(i1)
+		require.NoError(t, err)
+
+		synced, _, syncErr, serveErr := run(t, local, remote)
+		require.NoError(t, syncErr)
+		require.NoError(t, serveErr)
+		require.Len(t, synced.Difference.Left, 20)
+		require.True(t, synced.Size < 1024, "decoded at %d", synced.Size)
+
+		// Sets that can't be folded are only available at their size.
+		plain, err := NewSet(ibf.NewIBF(1024, 1))
+		require.NoError(t, err)
+		require.Equal(t, []uint64{1024}, plain.GetSizes())
+	})
+
+	t.Run("transfer", func(t *testing.T) {
+		opts := ibf.DefaultOptions
+		opts.HashKeys = true
+
+		b0, err := ibf.NewBundle([]uint64{20, 60}, 1, opts)
+		require.NoError(t, err)
+
+		b1 := b0.Clone()
+
+		fill(t, b0.Insert, 0, 100)
+		fill(t, b1.Insert, 3, 105)
+
+		// resolver returns the elements between start and end whose
+		// digests are wanted.
+		resolver := func(start, end int) func(keys [][]byte) ([][]byte, error) {
+			return func(keys [][]byte) (elements [][]byte, err error) {
+				wanted := map[string]bool{}
+				for _, key := range keys {
+					wanted[string(key)] = true
+				}
+
+				for j := start; j < end; j++ {
+					element := []byte(strconv.Itoa(j))
+					if wanted[string(ibf.KeyDigest(element))] {
+						elements = append(elements, element)
+					}
+				}
+
+				return elements, nil
+			}
+		}
+
+		local := &Set{Bundle: b0, Resolve: resolver(0, 100)}
+		remote := &Set{Bundle: b1, Resolve: resolver(3, 105)}
+
+		a, b := net.Pipe()
+
+		done := make(chan struct{})
+
+		var served *Result
+		var serveErr error
+
+		go func() {
+			defer close(done)
+
+			served, serveErr = Serve(b, remote)
+		}()
+
+		client, err := NewClient(a, "")
+		require.NoError(t, err)
+
+		synced, err := client.Transfer(local)
+		require.NoError(t, err)
+		require.NoError(t, a.Close())
+
+		<-done
+
+		require.NoError(t, serveErr)
+		require.Equal(t, []string{"100", "101", "102", "103", "104"}, strs(synced.Received))
+		require.Equal(t, []string{"0", "1", "2"}, strs(served.Received))
+	})
+
+	t.Run("transfer rejected", func(t *testing.T) {
+		b0, err := ibf.NewBundle([]uint64{20, 60}, 1, ibf.DefaultOptions)
+		require.NoError(t, err)
+
+		b1 := b0.Clone()
+
+		fill(t, b0.Insert, 0, 100)
+		fill(t, b1.Insert, 3, 105)
+
+		// transfer runs a transfer of the local set with the remote
+		// set.
+		transfer := func(local, remote *Set) (syncErr, serveErr error) {
+			a, b := net.Pipe()
+
+			done := make(chan struct{})
+
+			go func() {
+				defer close(done)
+				defer func() { _ = b.Close() }()
+
+				_, serveErr = Serve(b, remote)
+			}()
+
+			client, err := NewClient(a, "")
+			require.NoError(t, err)
+
+			_, syncErr = client.Transfer(local)
+			_ = a.Close()
+
+			<-done
+
+			return syncErr, serveErr
+		}
+
+		// extra returns the keys along with an element outside of the
+		// difference.
+		extra := func(keys [][]byte) ([][]byte, error) {
+			return append(keys, []byte("999")), nil
+		}
+
+		// same returns the keys, which are the elements.
+		same := func(keys [][]byte) ([][]byte, error) {
+			return keys, nil
+		}
+
+		// A server without Resolve doesn't accept elements.
+		syncErr, serveErr := transfer(&Set{Bundle: b0}, &Set{Bundle: b1})
+		require.True(t, ErrPeer.Has(syncErr))
+		require.Error(t, serveErr)
+
+		// Elements outside of the difference are rejected by both
+		// sides.
+		syncErr, serveErr = transfer(&Set{Bundle: b0, Resolve: extra}, &Set{Bundle: b1, Resolve: same})
+		require.True(t, ErrPeer.Has(syncErr))
+		require.Error(t, serveErr)
+
+		syncErr, serveErr = transfer(&Set{Bundle: b0}, &Set{Bundle: b1, Resolve: extra})
+		require.Error(t, syncErr)
+		require.NoError(t, serveErr)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		b0, err := ibf.NewBundle([]uint64{20, 60}, 1, ibf.DefaultOptions)
+		require.NoError(t, err)
+
+		b1 := b0.Clone()
+
+		fill(t, b0.Insert, 0, 1000)
+
+		_, _, syncErr, serveErr := run(t, &Set{Bundle: b0}, &Set{Bundle: b1})
+		require.Equal(t, ErrTooLarge, syncErr)
+		require.True(t, ErrPeer.Has(serveErr))
+	})
+
+	t.Run("incompatible", func(t *testing.T) {
+		local := &Set{IBF: ibf.NewIBF(100, 1)}
+		remote := &Set{IBF: ibf.NewIBF(100, 2)}
+
+		_, _, syncErr, serveErr := run(t, local, remote)
+		require.Error(t, syncErr)
+		require.True(t, ErrPeer.Has(serveErr))
+	})
+
+	t.Run("frame size", func(t *testing.T) {
+		var scratch [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(scratch[:], maxFrameSize)
+
+		// NOTE: A peer declaring a large frame without sending it
+		// must not make the server allocate its size.
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+
+		_, err := Serve(struct {
+			io.Reader
+			io.Writer
+		}{
+			Reader: bytes.NewReader(append(scratch[:n], frameHello)),
+			Writer: ioutil.Discard,
+		}, &Set{IBF: ibf.NewIBF(100, 1)})
+		require.Error(t, err)
+
+		runtime.ReadMemStats(&after)
+		require.True(t, after.TotalAlloc-before.TotalAlloc < maxFrameSize/16)
+	})
+}
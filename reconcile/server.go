@@ -0,0 +1,251 @@
+package reconcile
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+// ParseAddress splits an address given as unix:PATH or tcp:HOST:PORT into the
+// network and address expected by the net package.
+func ParseAddress(addr string) (network, address string, err error) {
+	j := strings.Index(addr, ":")
+	if j < 0 {
+		return "", "", Error.New("address %q must be unix:PATH or tcp:HOST:PORT", addr)
+	}
+
+	network, address = addr[:j], addr[j+1:]
+
+	switch network {
+	case "unix", "tcp":
+	default:
+		return "", "", Error.New("unsupported network %q", network)
+	}
+
+	if address == "" {
+		return "", "", Error.New("address %q must be unix:PATH or tcp:HOST:PORT", addr)
+	}
+
+	return network, address, nil
+}
+
+// Listen listens on the address given as unix:PATH or tcp:HOST:PORT.
+func Listen(addr string) (net.Listener, error) {
+	network, address, err := ParseAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	return l, nil
+}
+
+// Server serves named sets to any number of concurrent clients.
+//
+// NOTE: Sessions only read the sets, so a set must not be modified once it is
+// added. Adding a set under the same name replaces it for new sessions.
+type Server struct {
+	// OnResult, if set, is called with the result of every session that
+	// reconciled. It may be called concurrently.
+	OnResult func(name string, res *Result)
+
+	// OnError, if set, is called with the error of every session that
+	// failed. It may be called concurrently.
+	OnError func(name string, err error)
+
+	// IdleTimeout, if set, ends sessions whose connection sends or
+	// accepts nothing for that long, including while waiting for the
+	// elements after the result.
+	IdleTimeout time.Duration
+
+	mu        sync.Mutex
+	sets      map[string]*Set
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewServer returns a server without any sets.
+func NewServer() *Server {
+	return &Server{
+		sets:      map[string]*Set{},
+		listeners: map[net.Listener]struct{}{},
+		conns:     map[net.Conn]struct{}{},
+	}
+}
+
+// Add serves the set under the name.
+func (s *Server) Add(name string, set *Set) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sets[name] = set
+}
+
+// GetNames returns the sorted names of the sets served.
+func (s *Server) GetNames() (names []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name := range s.sets {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// lookup returns the set with the name. An empty name selects the only set,
+// whose name is returned.
+func (s *Server) lookup(name string) (string, *Set, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if name == "" && len(s.sets) == 1 {
+		for name, set := range s.sets {
+			return name, set, nil
+		}
+	}
+
+	set, ok := s.sets[name]
+	if !ok {
+		return name, nil, Error.New("no set named %q", name)
+	}
+
+	return name, set, nil
+}
+
+// Serve accepts connections on the listener and serves each in its own
+// goroutine. It returns nil once the server is closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+
+		return Error.New("server closed")
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.mu.Unlock()
+
+			if closed {
+				return nil
+			}
+
+			return Error.Wrap(err)
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = nc.Close()
+
+			return nil
+		}
+		s.conns[nc] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+
+			_ = s.ServeConn(nc)
+
+			s.mu.Lock()
+			delete(s.conns, nc)
+			s.mu.Unlock()
+
+			_ = nc.Close()
+		}()
+	}
+}
+
+// ServeConn serves a single session over the connection.
+func (s *Server) ServeConn(nc net.Conn) error {
+	var name string
+
+	if s.IdleTimeout > 0 {
+		nc = idleConn{Conn: nc, timeout: s.IdleTimeout}
+	}
+
+	res, err := serve(newConn(nc), func(n string) (set *Set, err error) {
+		name, set, err = s.lookup(n)
+
+		return set, err
+	})
+	if err != nil {
+		if s.OnError != nil {
+			s.OnError(name, err)
+		}
+
+		return err
+	}
+
+	if res != nil && s.OnResult != nil {
+		s.OnResult(name, res)
+	}
+
+	return nil
+}
+
+// Close stops the listeners, closes the open connections and waits for their
+// sessions to end.
+func (s *Server) Close() (err error) {
+	s.mu.Lock()
+	s.closed = true
+
+	for l := range s.listeners {
+		err = errs.Combine(err, l.Close())
+	}
+
+	for nc := range s.conns {
+		err = errs.Combine(err, nc.Close())
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	return Error.Wrap(err)
+}
+
+// idleConn extends the deadlines of a connection on every read and write, so
+// that it only fails once it was idle for the timeout.
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c idleConn) Read(p []byte) (n int, err error) {
+	err = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	if err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Read(p)
+}
+
+func (c idleConn) Write(p []byte) (n int, err error) {
+	err = c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	if err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Write(p)
+}
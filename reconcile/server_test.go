@@ -0,0 +1,195 @@
+package reconcile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	ibf "github.com/calebcase/ibf/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	// start runs the server on the address and returns the address
+	// clients dial.
+	start := func(t *testing.T, s *Server, addr string) string {
+		l, err := Listen(addr)
+		require.NoError(t, err)
+
+		go func() { _ = s.Serve(l) }()
+
+		return l.Addr().Network() + ":" + l.Addr().String()
+	}
+
+	b0, err := ibf.NewBundle([]uint64{20, 60, 200}, 1, ibf.DefaultOptions)
+	require.NoError(t, err)
+
+	b1 := b0.Clone()
+
+	fill(t, b0.Insert, 0, 1000)
+	fill(t, b1.Insert, 10, 1020)
+
+	i0 := ibf.NewIBF(100, 1)
+	fill(t, i0.Insert, 0, 5)
+
+	t.Run("tcp", func(t *testing.T) {
+		s := NewServer()
+		s.Add("bundle", &Set{Bundle: b0})
+		s.Add("ibf", &Set{IBF: i0})
+
+		results := make(chan *Result, 8)
+
+		s.OnResult = func(name string, res *Result) {
+			assert.Equal(t, "bundle", name)
+
+			results <- res
+		}
+
+		addr := start(t, s, "tcp:127.0.0.1:0")
+		defer func() { require.NoError(t, s.Close()) }()
+
+		var wg sync.WaitGroup
+
+		for j := 0; j < 8; j++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				client, err := Dial(addr, "bundle")
+				if !assert.NoError(t, err) {
+					return
+				}
+				defer func() { assert.NoError(t, client.Close()) }()
+
+				res, err := client.Sync(&Set{Bundle: b1})
+				if !assert.NoError(t, err) {
+					return
+				}
+
+				assert.Len(t, res.Difference.Left, 20)
+				assert.Len(t, res.Difference.Right, 10)
+			}()
+		}
+
+		wg.Wait()
+
+		// Fetching the parameters and an IBF.
+		client, err := Dial(addr, "ibf")
+		require.NoError(t, err)
+
+		require.Equal(t, []uint64{100}, client.GetSizes())
+		require.False(t, client.HasStrata())
+
+		fingerprint, ok := client.GetFingerprint(100)
+		require.True(t, ok)
+		require.Equal(t, i0.Fingerprint(), fingerprint)
+
+		set, err := client.Fetch(100)
+		require.NoError(t, err)
+		require.Equal(t, i0, set)
+
+		_, err = client.Fetch(50)
+		require.True(t, ErrPeer.Has(err))
+		require.NoError(t, client.Close())
+
+		// Sets must be named when there is more than one.
+		_, err = Dial(addr, "")
+		require.True(t, ErrPeer.Has(err))
+
+		_, err = Dial(addr, "missing")
+		require.True(t, ErrPeer.Has(err))
+
+		// NOTE: The server reports a result once the client ends the
+		// session, which may be after the client returned.
+		for j := 0; j < 8; j++ {
+			select {
+			case res := <-results:
+				require.Len(t, res.Difference.Left, 10)
+				require.Len(t, res.Difference.Right, 20)
+			case <-time.After(10 * time.Second):
+				require.FailNow(t, "missing result")
+			}
+		}
+	})
+
+	t.Run("unix", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "reconcile")
+		require.NoError(t, err)
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		s := NewServer()
+		s.Add("bundle", &Set{Bundle: b0})
+
+		addr := start(t, s, "unix:"+filepath.Join(dir, "ibf.sock"))
+
+		// The only set is selected without a name.
+		client, err := Dial(addr, "")
+		require.NoError(t, err)
+
+		res, err := client.Sync(&Set{Bundle: b1})
+		require.NoError(t, err)
+		require.Len(t, res.Difference.Left, 20)
+		require.NoError(t, client.Close())
+
+		// Closing the server ends open sessions.
+		client, err = Dial(addr, "")
+		require.NoError(t, err)
+
+		require.NoError(t, s.Close())
+
+		_, err = client.Fetch(20)
+		require.Error(t, err)
+		require.NoError(t, client.Close())
+	})
+
+	t.Run("idle", func(t *testing.T) {
+		s := NewServer()
+		s.Add("bundle", &Set{Bundle: b0})
+		s.IdleTimeout = 100 * time.Millisecond
+
+		errors := make(chan error, 1)
+
+		s.OnError = func(name string, err error) {
+			errors <- err
+		}
+
+		addr := start(t, s, "tcp:127.0.0.1:0")
+		defer func() { require.NoError(t, s.Close()) }()
+
+		client, err := Dial(addr, "bundle")
+		require.NoError(t, err)
+		defer func() { require.NoError(t, client.Close()) }()
+
+		select {
+		case err := <-errors:
+			require.Error(t, err)
+		case <-time.After(10 * time.Second):
+			require.FailNow(t, "idle session was not ended")
+		}
+
+		_, err = client.Fetch(20)
+		require.Error(t, err)
+	})
+
+	t.Run("address", func(t *testing.T) {
+		network, address, err := ParseAddress("tcp:127.0.0.1:7000")
+		require.NoError(t, err)
+		require.Equal(t, "tcp", network)
+		require.Equal(t, "127.0.0.1:7000", address)
+
+		network, address, err = ParseAddress("unix:/run/ibf.sock")
+		require.NoError(t, err)
+		require.Equal(t, "unix", network)
+		require.Equal(t, "/run/ibf.sock", address)
+
+		for _, addr := range []string{"", "tcp", "tcp:", "udp:127.0.0.1:7000"} {
+			_, _, err = ParseAddress(addr)
+			require.Error(t, err, addr)
+		}
+	})
+}
@@ -0,0 +1,168 @@
+package reconcile
+
+import (
+	"sort"
+
+	ibf "github.com/calebcase/ibf/lib"
+)
+
+// DefaultMinSize is the smallest size a foldable IBF is folded down to.
+const DefaultMinSize = 32
+
+// Set is a local set offered for reconciliation. It is backed by either a
+// bundle, whose members give the available sizes, or an IBF. An IBF using the
+// modulo or partitioned placement is also available folded down by powers of
+// two.
+type Set struct {
+	IBF    *ibf.IBF
+	Bundle *ibf.Bundle
+
+	// Strata is an optional estimator of the set. When both peers have
+	// one the reconciliation starts with a size large enough for the
+	// estimated difference.
+	Strata *ibf.Strata
+
+	// MinSize is the smallest size a foldable IBF is folded down to. If
+	// zero DefaultMinSize is used.
+	MinSize uint64
+
+	// Resolve, if set, returns the elements of keys only this set has so
+	// that they can be transferred to the peer. Sets that hash their keys
+	// need it to find the elements of the digests. If nil the keys are the
+	// elements.
+	//
+	// NOTE: A server only exchanges elements for sets with Resolve, since
+	// the client expects the elements it sends to be kept.
+	Resolve func(keys [][]byte) ([][]byte, error)
+}
+
+// NewSet returns a set backed by v, which must be an *ibf.IBF or an
+// *ibf.Bundle.
+func NewSet(v interface{}) (*Set, error) {
+	switch v := v.(type) {
+	case *ibf.IBF:
+		return &Set{IBF: v}, nil
+	case *ibf.Bundle:
+		return &Set{Bundle: v}, nil
+	}
+
+	return nil, Error.New("can't reconcile a %T", v)
+}
+
+// GetSizes returns the sizes the set is available at from smallest to
+// largest.
+func (s *Set) GetSizes() (sizes []uint64) {
+	if s.Bundle != nil {
+		return s.Bundle.GetSizes()
+	}
+
+	minSize := s.MinSize
+	if minSize == 0 {
+		minSize = DefaultMinSize
+	}
+
+	hashes := uint64(len(s.IBF.Positioners))
+	width := s.IBF.Size / hashes
+
+	sizes = append(sizes, s.IBF.Size)
+
+	// NOTE: Folding keeps halving the size for as long as Fold accepts the
+	// factor and the result isn't below the minimum size.
+	for factor := uint64(2); s.IBF.Size%factor == 0; factor *= 2 {
+		size := s.IBF.Size / factor
+		if size < minSize || size < hashes {
+			break
+		}
+
+		foldable := s.IBF.Placement == ibf.PlacementModulo ||
+			s.IBF.Placement == ibf.PlacementPartitioned && width%factor == 0
+		if !foldable {
+			break
+		}
+
+		sizes = append(sizes, size)
+	}
+
+	sort.Slice(sizes, func(a, b int) bool { return sizes[a] < sizes[b] })
+
+	return sizes
+}
+
+// GetIBF returns a copy of the set at the size.
+func (s *Set) GetIBF(size uint64) (*ibf.IBF, error) {
+	if s.Bundle != nil {
+		for _, member := range s.Bundle.Members {
+			if member.Size == size {
+				return member.Clone(), nil
+			}
+		}
+
+		return nil, Error.New("no member of size %d", size)
+	}
+
+	if size == s.IBF.Size {
+		return s.IBF.Clone(), nil
+	}
+
+	if size == 0 || s.IBF.Size%size != 0 {
+		return nil, Error.New("size %d is not available", size)
+	}
+
+	return s.IBF.Fold(s.IBF.Size / size)
+}
+
+// resolve returns the elements of the keys.
+func (s *Set) resolve(keys [][]byte) ([][]byte, error) {
+	if s.Resolve == nil {
+		return keys, nil
+	}
+
+	return s.Resolve(keys)
+}
+
+// hashesKeys returns true if the set holds the KeyDigest of its elements.
+func (s *Set) hashesKeys() bool {
+	if s.Bundle != nil {
+		return s.Bundle.Members[0].HashKeys
+	}
+
+	return s.IBF.HashKeys
+}
+
+// check returns an error unless the elements received are exactly those of
+// the keys only the peer has.
+func (s *Set) check(elements, keys [][]byte) error {
+	if len(elements) != len(keys) {
+		return Error.New("received %d elements, expected %d", len(elements), len(keys))
+	}
+
+	wanted := map[string]int{}
+	for _, key := range keys {
+		wanted[string(key)]++
+	}
+
+	for _, element := range elements {
+		key := element
+		if s.hashesKeys() {
+			key = ibf.KeyDigest(element)
+		}
+
+		if wanted[string(key)] == 0 {
+			return Error.New("received an element outside of the difference")
+		}
+
+		wanted[string(key)]--
+	}
+
+	return nil
+}
+
+// GetFingerprint returns the fingerprint of the parameters of the set at the
+// size.
+func (s *Set) GetFingerprint(size uint64) uint64 {
+	if s.Bundle != nil {
+		return s.Bundle.GetFingerprint(size)
+	}
+
+	return s.IBF.GetFingerprint(size)
+}
@@ -0,0 +1,275 @@
+package reconcile
+
+import (
+	"io"
+
+	ibf "github.com/calebcase/ibf/lib"
+)
+
+// Result is the outcome of a reconciliation.
+type Result struct {
+	// Difference holds in Left the elements only the local set has and in
+	// Right the elements only the remote set has.
+	Difference *ibf.Difference
+
+	// Size is the size of the IBFs the difference was decoded with.
+	Size uint64
+
+	// Received holds the elements received from the peer when the
+	// elements were transferred.
+	Received [][]byte
+}
+
+// Sync reconciles the local set with the set served by the peer at the other
+// end of rw. See Client.Sync.
+func Sync(rw io.ReadWriter, local *Set) (*Result, error) {
+	client, err := NewClient(rw, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Sync(local)
+}
+
+// Peer is the remote side of a reconciliation, a set that can be fetched at
+// the sizes it is available at.
+type Peer interface {
+	// GetSizes returns the sizes the set is available at from smallest
+	// to largest.
+	GetSizes() []uint64
+
+	// GetFingerprint returns the fingerprint of the parameters of the
+	// set at the size. It returns false if the set isn't available at
+	// the size.
+	GetFingerprint(size uint64) (uint64, bool)
+
+	// HasStrata returns true if the set has an estimator.
+	HasStrata() bool
+
+	// Fetch returns the set at the size.
+	Fetch(size uint64) (*ibf.IBF, error)
+
+	// FetchStrata returns the estimator of the set.
+	FetchStrata() (*ibf.Strata, error)
+}
+
+// Diff computes the difference between the local set and the peer. It runs
+// an ibf.Reconciler initiator for the local set against a responder for the
+// peer, which fetches the sizes requested from the peer. The estimator of the
+// peer is fetched first when both sets have one.
+//
+// If no common size decodes it returns ErrTooLarge.
+func Diff(local *Set, remote Peer) (*Result, error) {
+	var estimator *ibf.Strata
+
+	if local.Strata != nil && remote.HasStrata() {
+		var err error
+
+		estimator, err = remote.FetchStrata()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	initiator := ibf.NewInitiator(local, local.Strata)
+	responder := ibf.NewResponder(peerSource{remote}, estimator)
+
+	// NOTE: An error on either side ends the reconciliation with that
+	// error rather than passing on the failure message, so that errors
+	// fetching from the peer are returned as is.
+	queue := initiator.Start()
+
+	for len(queue) > 0 {
+		var replies []*ibf.Message
+
+		for _, m := range queue {
+			out, err := responder.Handle(m)
+			if err != nil {
+				return nil, err
+			}
+
+			replies = append(replies, out...)
+		}
+
+		queue = nil
+
+		for _, m := range replies {
+			out, err := initiator.Handle(m)
+			if err != nil {
+				return nil, err
+			}
+
+			queue = append(queue, out...)
+		}
+	}
+
+	diff, size, err := initiator.Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		Difference: diff,
+		Size:       size,
+	}, nil
+}
+
+// peerSource is the ibf.Source of a peer.
+type peerSource struct {
+	Peer
+}
+
+// GetFingerprint returns the fingerprint of the peer at the size.
+func (p peerSource) GetFingerprint(size uint64) uint64 {
+	fingerprint, _ := p.Peer.GetFingerprint(size)
+
+	return fingerprint
+}
+
+// GetIBF fetches the peer at the size.
+func (p peerSource) GetIBF(size uint64) (*ibf.IBF, error) {
+	return p.Fetch(size)
+}
+
+// Serve answers the requests of a client for the local set until it sends
+// the result, fails or closes the stream. The returned difference holds in
+// Left the elements only the local set has and in Right the elements only the
+// client has. If the client ends the session without reconciling the result
+// is nil.
+func Serve(rw io.ReadWriter, local *Set) (*Result, error) {
+	return serve(newConn(rw), func(name string) (*Set, error) {
+		return local, nil
+	})
+}
+
+// serve answers the requests of a client for the set returned by lookup for
+// the name in its hello. Each parameters message from the client starts a
+// new responder.
+func serve(c *conn, lookup func(name string) (*Set, error)) (*Result, error) {
+	payload, err := c.expect(frameHello)
+	if err != nil {
+		return nil, err
+	}
+
+	version, name, err := decodeHello(payload)
+	if err != nil {
+		return nil, c.fail(err)
+	}
+
+	if version != ProtocolVersion {
+		return nil, c.fail(Error.New("unsupported protocol version %d", version))
+	}
+
+	local, err := lookup(name)
+	if err != nil {
+		return nil, c.fail(err)
+	}
+
+	err = c.sendMessage(ibf.NewParams(local, local.Strata != nil))
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	var r *ibf.Reconciler
+
+	for {
+		kind, payload, err := c.recv()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if kind != frameMessage {
+			return nil, c.fail(Error.New("unexpected frame %d", kind))
+		}
+
+		m, err := decodeMessage(payload)
+		if err != nil {
+			return nil, c.fail(err)
+		}
+
+		switch {
+		case m.Type == ibf.MessageParams:
+			r = ibf.NewResponder(local, local.Strata)
+		case m.Type == ibf.MessageFail:
+			return nil, ErrPeer.New("%s", m.Reason)
+		case r == nil:
+			return nil, c.fail(Error.New("unexpected %s message", m.Type))
+		}
+
+		replies, err := r.Handle(m)
+
+		for _, reply := range replies {
+			sendErr := c.sendMessage(reply)
+			if sendErr != nil {
+				return nil, Error.Wrap(sendErr)
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if r.Done() {
+			diff, size, _ := r.Result()
+
+			return transfer(c, local, &Result{
+				Difference: diff,
+				Size:       size,
+			})
+		}
+	}
+}
+
+// transfer answers a client sending the elements the server lacks after the
+// result with the elements it lacks. Without a transfer the session is over.
+func transfer(c *conn, local *Set, res *Result) (*Result, error) {
+	kind, payload, err := c.recv()
+	if err == io.EOF {
+		return res, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if kind == frameMessage {
+		m, err := decodeMessage(payload)
+		if err == nil && m.Type == ibf.MessageFail {
+			return nil, ErrPeer.New("%s", m.Reason)
+		}
+	}
+
+	if kind != frameElements {
+		return nil, c.fail(Error.New("unexpected frame %d", kind))
+	}
+
+	if local.Resolve == nil {
+		return nil, c.fail(Error.New("set does not accept elements"))
+	}
+
+	received, err := decodeElements(payload)
+	if err != nil {
+		return nil, c.fail(err)
+	}
+
+	err = local.check(received, res.Difference.Right)
+	if err != nil {
+		return nil, c.fail(err)
+	}
+
+	elements, err := local.resolve(res.Difference.Left)
+	if err != nil {
+		return nil, c.fail(err)
+	}
+
+	err = c.send(frameElements, encodeElements(elements))
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	res.Received = received
+
+	return res, nil
+}